@@ -1,10 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"stori_challenge/internal/handlers"
+	"stori_challenge/pkg/csv"
+	"stori_challenge/pkg/email"
+	"stori_challenge/pkg/exchange"
+	"stori_challenge/pkg/i18n"
+	"stori_challenge/pkg/jobs"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/outbox"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -21,6 +34,50 @@ func main() {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
+	// Load the exchange rates every amount is converted into the account currency with
+	if _, err := exchange.DefaultProvider(); err != nil {
+		log.Fatalf("Error configuring the exchange rates: %v", err)
+	}
+
+	// Summaries without a language are written in DEFAULT_LOCALE, which must be supported
+	if _, err := i18n.Parse(""); err != nil {
+		log.Fatalf("Error configuring the default locale %q: %v", os.Getenv("DEFAULT_LOCALE"), err)
+	}
+
+	// Load the import profiles uploads can choose from
+	if err := csv.LoadProfiles(); err != nil {
+		log.Fatalf("Error loading the import profiles: %v", err)
+	}
+
+	// Select the mail transport the summary emails are delivered with
+	mailer, err := email.NewMailer()
+	if err != nil {
+		log.Fatalf("Error configuring the mail transport: %v", err)
+	}
+
+	// Start delivering the queued summary emails in the background
+	dispatcher, err := outbox.NewDispatcher(func(data models.EmailData) error {
+		return email.SendEmail(mailer, data)
+	})
+	if err != nil {
+		log.Fatalf("Error configuring the email dispatcher: %v", err)
+	}
+	if err := dispatcher.Start(); err != nil {
+		log.Fatalf("Error starting the email dispatcher: %v", err)
+	}
+
+	// Start the workers that import uploaded files in the background
+	pool, err := jobs.NewImportPool()
+	if err != nil {
+		log.Fatalf("Error configuring the import workers: %v", err)
+	}
+	pool.Start()
+
+	// Resume the jobs queued before the last shutdown
+	if err := jobs.Recover(pool); err != nil {
+		log.Printf("Error recovering import jobs: %v", err)
+	}
+
 	// Initialize a new Gin router
 	r := gin.Default()
 
@@ -31,9 +88,57 @@ func main() {
 		})
 	})
 
-	// Define a POST endpoint for uploading CSV files, delegating to the HandleCSVUpload handler
-	r.POST("/csv", handlers.HandleCSVUpload)
+	// Define a POST endpoint for uploading CSV files, queuing an import job on the worker pool
+	r.POST("/csv", handlers.HandleCSVUpload(pool))
+
+	// Define an endpoint to poll the state, progress and outcome of an import job
+	r.GET("/jobs/:id", handlers.HandleGetJob)
+
+	// Define endpoints to audit or revert a previous CSV import by its batch ID
+	r.GET("/imports/:id", handlers.HandleGetImport)
+	r.DELETE("/imports/:id", handlers.HandleRevertImport)
+
+	// Define an endpoint returning the summary of an account as JSON
+	r.GET("/accounts/:id/summary", handlers.HandleAccountSummary)
+
+	// Define an endpoint listing the subscriptions and other recurring debits of an account
+	r.GET("/accounts/:id/recurring", handlers.HandleRecurring)
+
+	// Define endpoints to manage the category rules of an account and apply them to its ledger
+	r.GET("/accounts/:id/rules", handlers.HandleListRules)
+	r.POST("/accounts/:id/rules", handlers.HandleCreateRule)
+	r.PUT("/accounts/:id/rules/:ruleId", handlers.HandleUpdateRule)
+	r.DELETE("/accounts/:id/rules/:ruleId", handlers.HandleDeleteRule)
+	r.POST("/accounts/:id/rules/apply", handlers.HandleApplyRules)
+
+	// Define endpoints to manage the monthly budgets of an account and list the alerts they raised
+	r.GET("/accounts/:id/budgets", handlers.HandleListBudgets)
+	r.POST("/accounts/:id/budgets", handlers.HandleCreateBudget)
+	r.PUT("/accounts/:id/budgets/:budgetId", handlers.HandleUpdateBudget)
+	r.DELETE("/accounts/:id/budgets/:budgetId", handlers.HandleDeleteBudget)
+	r.GET("/accounts/:id/alerts", handlers.HandleListAlerts)
+
+	// Define an endpoint to preview the summary email of an account or import without sending it
+	r.GET("/summary/preview", handlers.HandleSummaryPreview)
 
 	// Start the Gin server on the specified host port from environment variables
-	r.Run(":" + os.Getenv("HOST_PORT"))
+	srv := &http.Server{Addr: ":" + os.Getenv("HOST_PORT"), Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Error starting the server: %v", err)
+		}
+	}()
+
+	// Wait for an interrupt, then stop taking requests and let running jobs finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down the server: %v", err)
+	}
+	pool.Shutdown()
+	dispatcher.Shutdown()
 }
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"stori_challenge/pkg/budget"
+	"stori_challenge/pkg/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// HandleListBudgets returns the monthly budgets of the account identified by the :id path parameter.
+func HandleListBudgets(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	budgets, err := budget.ListBudgets(acc.Id)
+	if err != nil {
+		log.Printf("Error listing budgets: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the budgets"})
+		return
+	}
+	c.JSON(http.StatusOK, budgets)
+}
+
+// HandleCreateBudget adds a monthly budget, read from the JSON body, to the account identified by
+// the :id path parameter.
+func HandleCreateBudget(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	var b models.Budget
+	if err := c.ShouldBindJSON(&b); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid budget"})
+		return
+	}
+	b.Id = 0
+	b.AccountId = acc.Id
+
+	if !saveBudget(c, budget.CreateBudget, &b) {
+		return
+	}
+	c.JSON(http.StatusCreated, b)
+}
+
+// HandleUpdateBudget replaces the budget identified by the :budgetId path parameter with the JSON body.
+func HandleUpdateBudget(c *gin.Context) {
+	existing, ok := findBudget(c)
+	if !ok {
+		return
+	}
+
+	var b models.Budget
+	if err := c.ShouldBindJSON(&b); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid budget"})
+		return
+	}
+	b.Id = existing.Id
+	b.AccountId = existing.AccountId
+	b.CreatedAt = existing.CreatedAt
+
+	if !saveBudget(c, budget.UpdateBudget, &b) {
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// HandleDeleteBudget removes the budget identified by the :budgetId path parameter.
+func HandleDeleteBudget(c *gin.Context) {
+	b, ok := findBudget(c)
+	if !ok {
+		return
+	}
+
+	if err := budget.DeleteBudget(b.AccountId, b.Id); err != nil {
+		log.Printf("Error deleting budget: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting the budget"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Budget deleted successfully", "budgetId": b.Id})
+}
+
+// HandleListAlerts returns the budget alerts of the account identified by the :id path parameter,
+// newest first, or only those raised by the import given by the import_id query parameter.
+func HandleListAlerts(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+	importId, ok := parseIdQuery(c, "import_id")
+	if !ok {
+		return
+	}
+
+	alerts, err := budget.ListAlerts(acc.Id, importId)
+	if err != nil {
+		log.Printf("Error listing budget alerts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the budget alerts"})
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+// findBudget resolves the budget identified by the :budgetId path parameter within the account of
+// the :id path parameter, responding with an error when it can't be found.
+func findBudget(c *gin.Context) (models.Budget, bool) {
+	accountId, ok := parseIdParam(c)
+	if !ok {
+		return models.Budget{}, false
+	}
+	budgetId, err := strconv.ParseUint(c.Param("budgetId"), 10, 0)
+	if err != nil || budgetId == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid budget ID"})
+		return models.Budget{}, false
+	}
+
+	b, err := budget.GetBudget(accountId, uint(budgetId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Budget not found"})
+		return models.Budget{}, false
+	}
+	if err != nil {
+		log.Printf("Error retrieving budget: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the budget"})
+		return models.Budget{}, false
+	}
+	return b, true
+}
+
+// saveBudget stores a budget with save, responding with 400 when it is invalid and 409 when the
+// account already has a budget for its category.
+func saveBudget(c *gin.Context, save func(*models.Budget) error, b *models.Budget) bool {
+	err := save(b)
+	switch {
+	case errors.Is(err, budget.ErrInvalidBudget):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	case errors.Is(err, budget.ErrDuplicateBudget):
+		c.JSON(http.StatusConflict, gin.H{"error": "A budget already exists for the category"})
+		return false
+	case err != nil:
+		log.Printf("Error saving budget: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving the budget"})
+		return false
+	}
+	return true
+}
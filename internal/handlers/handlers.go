@@ -1,18 +1,62 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"stori_challenge/pkg/account"
 	"stori_challenge/pkg/csv"
 	"stori_challenge/pkg/email"
-	"stori_challenge/pkg/summary"
+	"stori_challenge/pkg/i18n"
+	"stori_challenge/pkg/imports"
+	"stori_challenge/pkg/jobs"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/outbox"
+	"stori_challenge/pkg/statement"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-// HandleCSVUpload handles the CSV file upload and summary creation.
-func HandleCSVUpload(c *gin.Context) {
+// Limits applied to the multipart form of an upload.
+const (
+	formMemory   = 32 << 20 // Bytes of the form kept in memory, the rest is spooled to disk
+	formOverhead = 1 << 20  // Bytes allowed on top of the file size limit for the other fields
+)
+
+// HandleCSVUpload returns the handler of CSV file uploads. The upload is validated and spooled,
+// then an import job is queued on pool to import the file and send the summary, and the handler
+// responds with 202 and the job ID to poll with HandleGetJob.
+func HandleCSVUpload(pool *jobs.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handleCSVUpload(c, pool)
+	}
+}
+
+// handleCSVUpload validates and queues a single upload.
+func handleCSVUpload(c *gin.Context, pool *jobs.Pool) {
+	// Reject oversized uploads while they are received instead of after storing them
+	limit, err := csv.FileSizeLimit()
+	if err != nil {
+		log.Printf("Error reading file size limit: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid file size limit"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+formOverhead)
+	if err := c.Request.ParseMultipartForm(formMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File exceeds the allowed size limit"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
+		return
+	}
+
 	// Retrieve the email address from the form data
 	emailWithSummary := c.PostForm("email")
 
@@ -22,6 +66,63 @@ func HandleCSVUpload(c *gin.Context) {
 		return
 	}
 
+	// Optional language of the summary; it also becomes the preference of a new account
+	lang := c.PostForm("lang")
+	if lang != "" {
+		if lang, err = i18n.Parse(lang); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported language"})
+			return
+		}
+	}
+
+	// Resolve the account that owns the uploaded ledger, creating it on first upload
+	acc, err := account.FindOrCreateAccount(emailWithSummary, c.PostForm("name"), c.PostForm("currency"), lang)
+	switch {
+	case errors.Is(err, account.ErrInvalidCurrency):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid currency code"})
+		return
+	case errors.Is(err, account.ErrUnsupportedLocale):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported language"})
+		return
+	case err != nil:
+		log.Printf("Error resolving account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not resolve the account"})
+		return
+	}
+
+	// Write the summary in the requested language, or in the account's preferred one
+	locale := lang
+	if locale == "" {
+		locale = acc.Locale
+	}
+
+	// Choose how rows that can't be imported are handled (strict or lenient)
+	policy, err := csv.ParsePolicy(c.PostForm("policy"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import policy"})
+		return
+	}
+
+	// Choose how rows already in the account's ledger are handled (skip, overwrite or fail)
+	onConflict, err := csv.ParseConflictPolicy(c.PostForm("on_conflict"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conflict policy"})
+		return
+	}
+
+	// Optional year for dates written as M/D; inferred from the months the file spans when missing
+	year, err := csv.ParseYear(c.PostForm("year"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year"})
+		return
+	}
+
+	// Optional period summarized in the email; the whole ledger when missing
+	period, ok := parsePeriod(c, c.PostForm)
+	if !ok {
+		return
+	}
+
 	// Retrieve the uploaded CSV file from the form
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -29,49 +130,228 @@ func HandleCSVUpload(c *gin.Context) {
 		return
 	}
 
-	// Create a temporary file to store the uploaded CSV
-	tempFile, err := os.CreateTemp("", "csv-*.csv")
+	// Optional statements attached to the summary email
+	attachments, err := parseAttachments(c)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Reject unknown profiles now rather than once the job runs
+	if _, err := csv.LookupProfile(c.PostForm("profile")); err != nil {
+		if errors.Is(err, csv.ErrUnknownProfile) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown import profile"})
+			return
+		}
+		log.Printf("Error loading import profiles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load the import profiles"})
+		return
+	}
+
+	// Spool the uploaded CSV until a worker imports it; the job removes it once it finishes
+	spoolFile, err := jobs.SpoolFile()
+	if err != nil {
+		log.Printf("Error creating spool file: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create temporary file"})
 		return
 	}
-	defer os.Remove(tempFile.Name()) // Ensure the temporary file is removed after use
-	defer tempFile.Close()           // Close the temporary file when done
+	spoolFile.Close() // SaveUploadedFile reopens it by name
+	queued := false
+	defer func() {
+		if !queued {
+			os.Remove(spoolFile.Name())
+		}
+	}()
 
-	// Save the uploaded file to the temporary location
-	if err := c.SaveUploadedFile(file, tempFile.Name()); err != nil {
+	// Save the uploaded file to the spool location
+	if err := c.SaveUploadedFile(file, spoolFile.Name()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save the file"})
 		return
 	}
 
 	// Check the size of the uploaded file
-	if err := csv.CheckFileSize(tempFile.Name()); err != nil {
+	if err := csv.CheckFileSize(spoolFile.Name()); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "File exceeds the allowed size limit"})
 		return
 	}
 
-	// Process the uploaded CSV file
-	if err := csv.ProcessCSVFile(tempFile.Name()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing the CSV file"})
+	// Record the import job with everything needed to run it
+	job := models.ImportJob{AccountId: acc.Id}
+	params := jobs.ImportParams{
+		FilePath: spoolFile.Name(),
+		Email:    emailWithSummary,
+		Options: csv.ImportOptions{
+			AccountId:  acc.Id,
+			FileName:   file.Filename,
+			Uploader:   emailWithSummary,
+			Policy:     policy,
+			Year:       year,
+			Currency:   acc.Currency,
+			Profile:    c.PostForm("profile"),
+			OnConflict: onConflict,
+		},
+		Attachments: attachments,
+		Locale:      locale,
+		Period:      period,
+	}
+	if err := jobs.Create(&job, params); err != nil {
+		log.Printf("Error creating import job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create the import job"})
+		return
+	}
+
+	// Queue the job, refusing the upload when the workers are saturated
+	if err := pool.Enqueue(job.Id); err != nil {
+		log.Printf("Error enqueuing job %d: %v", job.Id, err)
+		if err := jobs.Finish(&job, nil, err); err != nil {
+			log.Println("Error:", err)
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many imports in progress, try again later", "jobId": job.Id})
 		return
 	}
+	queued = true
 
-	// Create the summary from the processed data
-	provider := &summary.FinanceService{}
-	emailData, err := summary.CreateSummary(provider)
+	location := fmt.Sprintf("/jobs/%d", job.Id)
+	c.Header("Location", location)
+	c.JSON(http.StatusAccepted, gin.H{"message": "CSV file queued for import", "jobId": job.Id, "status": location})
+}
+
+// HandleGetJob reports the state, progress and outcome of the import job identified by the :id path parameter.
+func HandleGetJob(c *gin.Context) {
+	id, ok := parseIdParam(c)
+	if !ok {
+		return
+	}
+
+	job, err := jobs.Get(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating the summary"})
+		log.Printf("Error retrieving job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the job"})
 		return
 	}
-	emailData.EmailTo = emailWithSummary // Set the recipient email address
 
-	// Send the summary email
-	if err := email.SendEmail(emailData); err != nil {
-		log.Printf("Error sending email: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error sending the email"})
+	// Include the stored outcome as it was encoded by the job
+	c.JSON(http.StatusOK, struct {
+		models.ImportJob
+		Result json.RawMessage `json:"result,omitempty"`
+	}{job, json.RawMessage(job.Result)})
+}
+
+// HandleGetImport returns the import batch identified by the :id path parameter, along with
+// the delivery status of its summary emails.
+func HandleGetImport(c *gin.Context) {
+	id, ok := parseIdParam(c)
+	if !ok {
 		return
 	}
 
-	// Respond with a success message
-	c.JSON(http.StatusOK, gin.H{"message": "CSV file processed and summary sent successfully"})
+	batch, err := imports.GetBatch(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error retrieving import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the import"})
+		return
+	}
+
+	// Include the delivery status of the summary emails sent for the import
+	emails, err := outbox.ForImport(id)
+	if err != nil {
+		log.Printf("Error retrieving import emails: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the import"})
+		return
+	}
+
+	c.JSON(http.StatusOK, struct {
+		models.ImportBatch
+		Emails []models.OutboxEmail `json:"emails"`
+	}{batch, emails})
+}
+
+// HandleRevertImport removes the rows of the import batch identified by the :id path parameter.
+func HandleRevertImport(c *gin.Context) {
+	id, ok := parseIdParam(c)
+	if !ok {
+		return
+	}
+
+	deleted, err := imports.RevertBatch(id)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	case errors.Is(err, imports.ErrAlreadyReverted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Import already reverted"})
+		return
+	case errors.Is(err, imports.ErrStillProcessing):
+		c.JSON(http.StatusConflict, gin.H{"error": "Import still processing"})
+		return
+	case errors.Is(err, imports.ErrOverwrittenLater):
+		c.JSON(http.StatusConflict, gin.H{"error": "Import overwritten by a later import, revert that one first"})
+		return
+	case err != nil:
+		log.Printf("Error reverting import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reverting the import"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Import reverted successfully", "importId": id, "deletedRows": deleted})
+}
+
+// parseAttachments returns the statement formats requested with the attach_csv and attach_pdf form fields.
+func parseAttachments(c *gin.Context) ([]string, error) {
+	var formats []string
+	for _, format := range []string{statement.FormatCSV, statement.FormatPDF} {
+		field := "attach_" + format
+		value := c.PostForm(field)
+		if value == "" {
+			continue
+		}
+
+		attach, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for %s", field)
+		}
+		if attach {
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+// accountParam resolves the account identified by the :id path parameter, responding with an
+// error when it can't be found.
+func accountParam(c *gin.Context) (models.Account, bool) {
+	id, ok := parseIdParam(c)
+	if !ok {
+		return models.Account{}, false
+	}
+
+	acc, err := account.GetAccount(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return models.Account{}, false
+	}
+	if err != nil {
+		log.Printf("Error retrieving account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the account"})
+		return models.Account{}, false
+	}
+	return acc, true
+}
+
+// parseIdParam parses the :id path parameter, responding with 400 when it is not a valid ID.
+func parseIdParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return 0, false
+	}
+	return uint(id), true
 }
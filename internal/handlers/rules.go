@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"stori_challenge/pkg/category"
+	"stori_challenge/pkg/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// HandleListRules returns the category rules of the account identified by the :id path
+// parameter, in the order they are tried.
+func HandleListRules(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	rules, err := category.ListRules(acc.Id)
+	if err != nil {
+		log.Printf("Error listing category rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the category rules"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// HandleCreateRule adds a category rule, read from the JSON body, to the account identified by
+// the :id path parameter. It applies to the transactions imported from then on.
+func HandleCreateRule(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	var rule models.CategoryRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category rule"})
+		return
+	}
+	rule.Id = 0
+	rule.AccountId = acc.Id
+
+	if !saveRule(c, category.CreateRule, &rule) {
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// HandleUpdateRule replaces the category rule identified by the :ruleId path parameter with the
+// JSON body.
+func HandleUpdateRule(c *gin.Context) {
+	existing, ok := findRule(c)
+	if !ok {
+		return
+	}
+
+	var rule models.CategoryRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category rule"})
+		return
+	}
+	rule.Id = existing.Id
+	rule.AccountId = existing.AccountId
+	rule.CreatedAt = existing.CreatedAt
+
+	if !saveRule(c, category.UpdateRule, &rule) {
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// HandleDeleteRule removes the category rule identified by the :ruleId path parameter.
+func HandleDeleteRule(c *gin.Context) {
+	rule, ok := findRule(c)
+	if !ok {
+		return
+	}
+
+	if err := category.DeleteRule(rule.AccountId, rule.Id); err != nil {
+		log.Printf("Error deleting category rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting the category rule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Category rule deleted successfully", "ruleId": rule.Id})
+}
+
+// HandleApplyRules categorizes the whole ledger of the account identified by the :id path
+// parameter again with its current rules.
+func HandleApplyRules(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	changed, err := category.Recategorize(acc.Id)
+	if err != nil {
+		log.Printf("Error applying category rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error applying the category rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Category rules applied successfully", "accountId": acc.Id, "changedRows": changed})
+}
+
+// findRule resolves the category rule identified by the :ruleId path parameter within the
+// account of the :id path parameter, responding with an error when it can't be found.
+func findRule(c *gin.Context) (models.CategoryRule, bool) {
+	accountId, ok := parseIdParam(c)
+	if !ok {
+		return models.CategoryRule{}, false
+	}
+	ruleId, err := strconv.ParseUint(c.Param("ruleId"), 10, 0)
+	if err != nil || ruleId == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return models.CategoryRule{}, false
+	}
+
+	rule, err := category.GetRule(accountId, uint(ruleId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category rule not found"})
+		return models.CategoryRule{}, false
+	}
+	if err != nil {
+		log.Printf("Error retrieving category rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the category rule"})
+		return models.CategoryRule{}, false
+	}
+	return rule, true
+}
+
+// saveRule stores a rule with save, responding with 400 when the rule is invalid.
+func saveRule(c *gin.Context, save func(*models.CategoryRule) error, rule *models.CategoryRule) bool {
+	err := save(rule)
+	if errors.Is(err, category.ErrInvalidRule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		log.Printf("Error saving category rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving the category rule"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"stori_challenge/pkg/account"
+	"stori_challenge/pkg/anomaly"
+	"stori_challenge/pkg/budget"
+	"stori_challenge/pkg/email"
+	"stori_challenge/pkg/exchange"
+	"stori_challenge/pkg/i18n"
+	"stori_challenge/pkg/imports"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/recurring"
+	"stori_challenge/pkg/summary"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// HandleSummaryPreview renders the summary email of the account given by the account_id or
+// import_id query parameters straight into the response, as HTML or as plain text with
+// format=text, in the language given by lang or the account's preference. The period, from and
+// to query parameters limit the summary to a period. Previews of an import also list the budget
+// alerts it raised and the transactions it flagged. Nothing is sent.
+func HandleSummaryPreview(c *gin.Context) {
+	acc, importId, ok := previewAccount(c)
+	if !ok {
+		return
+	}
+
+	// Write the summary in the requested language, or in the account's preferred one
+	locale := acc.Locale
+	if lang := c.Query("lang"); lang != "" {
+		var err error
+		if locale, err = i18n.Parse(lang); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported language"})
+			return
+		}
+	}
+
+	format := c.DefaultQuery("format", "html")
+	if format != "html" && format != "text" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected html or text"})
+		return
+	}
+
+	period, ok := parsePeriod(c, c.Query)
+	if !ok {
+		return
+	}
+
+	rates, err := exchange.DefaultProvider()
+	if err != nil {
+		log.Printf("Error loading exchange rates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating the summary"})
+		return
+	}
+
+	// Create the summary exactly as it would be emailed
+	provider := summary.NewFinanceService(acc.Id)
+	emailData, err := summary.CreateSummary(provider, rates, acc.Currency, period)
+	if err != nil {
+		log.Printf("Error creating summary preview: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating the summary"})
+		return
+	}
+	emailData.EmailTo = acc.Email
+	emailData.Locale = locale
+
+	// Like the import job, render the summary without recurring debits when their detection fails
+	if emailData.Recurring, err = recurring.ForAccount(acc.Id, time.Now()); err != nil {
+		log.Printf("Error detecting recurring transactions of account %d: %v", acc.Id, err)
+		emailData.Recurring = nil
+	}
+
+	// The email of an import also reports the alerts it raised and the transactions it flagged
+	if importId != 0 {
+		if emailData.Alerts, err = budget.ListAlerts(acc.Id, importId); err != nil {
+			log.Printf("Error listing budget alerts: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating the summary"})
+			return
+		}
+		if emailData.Anomalies, err = anomaly.ForBatch(acc.Id, importId); err != nil {
+			log.Printf("Error listing flagged transactions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating the summary"})
+			return
+		}
+	}
+
+	if format == "text" {
+		body, err := email.RenderText(emailData)
+		if err != nil {
+			log.Printf("Error rendering summary preview: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rendering the email template"})
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(body))
+		return
+	}
+
+	body, err := email.RenderHTML(emailData)
+	if err != nil {
+		log.Printf("Error rendering summary preview: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rendering the email template"})
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(body))
+}
+
+// HandleAccountSummary returns the summary of the account identified by the :id path parameter
+// as JSON: the same figures CreateSummary puts in the summary email, in the account currency,
+// limited to the period given by the period, from and to query parameters.
+func HandleAccountSummary(c *gin.Context) {
+	period, ok := parsePeriod(c, c.Query)
+	if !ok {
+		return
+	}
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	rates, err := exchange.DefaultProvider()
+	if err != nil {
+		log.Printf("Error loading exchange rates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating the summary"})
+		return
+	}
+
+	provider := summary.NewFinanceService(acc.Id)
+	emailData, err := summary.CreateSummary(provider, rates, acc.Currency, period)
+	if err != nil {
+		log.Printf("Error creating summary: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating the summary"})
+		return
+	}
+
+	c.JSON(http.StatusOK, emailData)
+}
+
+// HandleRecurring returns the recurring debits, such as subscriptions, found in the ledger of the
+// account identified by the :id path parameter, with the predicted date of their next occurrence.
+func HandleRecurring(c *gin.Context) {
+	acc, ok := accountParam(c)
+	if !ok {
+		return
+	}
+
+	found, err := recurring.ForAccount(acc.Id, time.Now())
+	if err != nil {
+		log.Printf("Error detecting recurring transactions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error detecting the recurring transactions"})
+		return
+	}
+	c.JSON(http.StatusOK, found)
+}
+
+// previewAccount resolves the account of a preview from the account_id query parameter, or from
+// the import batch given by import_id, responding with an error when it can't be found. It also
+// returns the import ID, 0 when the preview is of an account.
+func previewAccount(c *gin.Context) (models.Account, uint, bool) {
+	accountId, ok := parseIdQuery(c, "account_id")
+	if !ok {
+		return models.Account{}, 0, false
+	}
+	importId, ok := parseIdQuery(c, "import_id")
+	if !ok {
+		return models.Account{}, 0, false
+	}
+	if (accountId == 0) == (importId == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected either account_id or import_id"})
+		return models.Account{}, 0, false
+	}
+
+	if importId != 0 {
+		batch, err := imports.GetBatch(importId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+			return models.Account{}, 0, false
+		}
+		if err != nil {
+			log.Printf("Error retrieving import: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the import"})
+			return models.Account{}, 0, false
+		}
+		accountId = batch.AccountId
+	}
+
+	acc, err := account.GetAccount(accountId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return models.Account{}, 0, false
+	}
+	if err != nil {
+		log.Printf("Error retrieving account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving the account"})
+		return models.Account{}, 0, false
+	}
+	return acc, importId, true
+}
+
+// parsePeriod reads the period of a summary from the period preset (month, quarter, ytd, year or
+// all) or the from/to dates, using get to look up each parameter, and responds with 400 when it
+// is invalid.
+func parsePeriod(c *gin.Context, get func(key string) string) (models.Period, bool) {
+	period, err := summary.ParsePeriod(get("period"), get("from"), get("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return models.Period{}, false
+	}
+	return period, true
+}
+
+// parseIdQuery parses an optional ID query parameter, returning 0 when it is missing and
+// responding with 400 when it is not a valid ID.
+func parseIdQuery(c *gin.Context, name string) (uint, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+
+	id, err := strconv.ParseUint(value, 10, 0)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,87 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/i18n"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// defaultCurrency is used when neither the request nor the environment specify a currency.
+const defaultCurrency = "USD"
+
+var (
+	// ErrInvalidCurrency is returned when a currency code is not a three-letter ISO 4217 code.
+	ErrInvalidCurrency = money.ErrInvalidCurrency
+	// ErrUnsupportedLocale is returned when the preferred locale isn't supported.
+	ErrUnsupportedLocale = i18n.ErrUnsupportedLocale
+)
+
+// FindOrCreateAccount returns the account owned by the given email, creating it if it doesn't exist yet.
+// The currency and locale are only used as the preferences of a new account.
+func FindOrCreateAccount(email, name, currency, locale string) (models.Account, error) {
+	var acc models.Account
+
+	email = normalizeEmail(email)
+	err := config.GetDB().Where("email = ?", email).First(&acc).Error
+	if err == nil {
+		return acc, nil // The account already exists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Account{}, fmt.Errorf("failed to look up account %s: %w", email, err)
+	}
+
+	currency, err = normalizeCurrency(currency)
+	if err != nil {
+		return models.Account{}, err
+	}
+	locale, err = i18n.Parse(locale)
+	if err != nil {
+		return models.Account{}, err
+	}
+
+	acc = models.Account{
+		Email:    email,
+		Name:     strings.TrimSpace(name),
+		Currency: currency,
+		Locale:   locale,
+	}
+
+	// FirstOrCreate protects against two uploads creating the same account concurrently
+	if err := config.GetDB().Where("email = ?", email).FirstOrCreate(&acc).Error; err != nil {
+		return models.Account{}, fmt.Errorf("failed to create account %s: %w", email, err)
+	}
+	return acc, nil
+}
+
+// GetAccount retrieves an account by its primary key.
+func GetAccount(id uint) (models.Account, error) {
+	var acc models.Account
+	if err := config.GetDB().First(&acc, id).Error; err != nil {
+		return models.Account{}, fmt.Errorf("failed to get account %d: %w", id, err)
+	}
+	return acc, nil
+}
+
+// normalizeEmail lowercases and trims the email so the same owner always maps to one account.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// normalizeCurrency validates a three-letter currency code, falling back to DEFAULT_CURRENCY or USD.
+func normalizeCurrency(currency string) (string, error) {
+	currency = strings.TrimSpace(currency)
+	if currency == "" {
+		currency = os.Getenv("DEFAULT_CURRENCY")
+	}
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	return money.ParseCurrency(currency)
+}
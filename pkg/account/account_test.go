@@ -0,0 +1,50 @@
+package account
+
+import (
+	"errors"
+	"testing"
+)
+
+// currencyPair defines a structure for holding currency normalization test cases.
+type currencyPair struct {
+	input    string // Currency code as received in the request
+	expected string // Normalized currency code
+	hasErr   bool   // Indicates if an error is expected for this test case
+}
+
+// List of test cases with corresponding expected outcomes
+var currencyTests = []currencyPair{
+	{"usd", "USD", false},
+	{" mxn ", "MXN", false},
+	{"", "USD", false}, // Falls back to the default currency
+	{"US", "", true},
+	{"U$D", "", true},
+}
+
+// TestNormalizeCurrency tests the normalizeCurrency function with various inputs
+func TestNormalizeCurrency(t *testing.T) {
+	t.Setenv("DEFAULT_CURRENCY", "")
+
+	for _, pair := range currencyTests {
+		currency, err := normalizeCurrency(pair.input)
+
+		// Check if the error result matches the expected outcome
+		if (err != nil) != pair.hasErr {
+			t.Errorf("For %q expected error: %v, got: %v", pair.input, pair.hasErr, err)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidCurrency) {
+			t.Errorf("For %q expected ErrInvalidCurrency, got: %v", pair.input, err)
+		}
+		if currency != pair.expected {
+			t.Errorf("For %q expected currency %q, got %q", pair.input, pair.expected, currency)
+		}
+	}
+}
+
+// TestNormalizeEmail tests that emails are trimmed and lowercased
+func TestNormalizeEmail(t *testing.T) {
+	if got := normalizeEmail("  Hector@Example.COM "); got != "hector@example.com" {
+		t.Errorf("expected hector@example.com, got %q", got)
+	}
+}
@@ -0,0 +1,151 @@
+package anomaly
+
+import (
+	"math"
+	"slices"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+	"strings"
+	"time"
+)
+
+// Flags stored in the Anomalies column of a transaction.
+const (
+	FlagAmount    = "amount"    // The amount is far from the account's usual amounts
+	FlagWeekday   = "weekday"   // The account rarely has transactions on that day of the week
+	FlagDuplicate = "duplicate" // Another transaction has the same amount and currency on the same date
+)
+
+// Limits of the detection.
+const (
+	minHistory      = 20 // Transactions the history needs before amounts or weekdays are judged
+	maxZScore       = 3  // Standard deviations an amount may be from the mean
+	minWeekdayShare = 2  // Percentage of the history a weekday needs to be usual
+	dateLayout      = "2006-01-02"
+)
+
+type (
+	// Detector flags the transactions of an import that deviate from the history of an account.
+	Detector struct {
+		amounts  map[amountKey]amountStats // Distribution of the amounts of the history
+		weekdays [7]int                    // Transactions of the history per day of the week
+		history  int                       // Transactions of the history
+	}
+
+	// amountKey groups the amounts compared with each other: debits and credits of a currency.
+	amountKey struct {
+		currency string // ISO 4217 code of the amounts
+		debit    bool   // The amounts are negative
+	}
+
+	// amountStats describes the distribution of the debits or credits of a currency, in cents.
+	amountStats struct {
+		Currency string  // ISO 4217 code of the amounts
+		Debit    bool    // The amounts are negative
+		Count    int     // Number of amounts
+		Mean     float64 // Arithmetic mean
+		StdDev   float64 // Population standard deviation
+	}
+
+	// ledgerRow is a stored transaction compared with the imported ones.
+	ledgerRow struct {
+		IdTransaction uint         // Transaction ID within the account
+		Date          time.Time    // Date of the transaction
+		Amount        money.Amount // Amount in its own currency
+		Currency      string       // ISO 4217 code of the amount
+	}
+
+	// duplicateKey identifies the transactions that are duplicates of each other.
+	duplicateKey struct {
+		date     string       // Date of the transaction, formatted as YYYY-MM-DD
+		amount   money.Amount // Exact amount
+		currency string       // ISO 4217 code of the amount
+	}
+)
+
+// newDetector creates a Detector from the distribution of the amounts of an account and the
+// number of its transactions on each day of the week.
+func newDetector(amounts []amountStats, weekdays [7]int) *Detector {
+	d := &Detector{
+		amounts:  make(map[amountKey]amountStats, len(amounts)),
+		weekdays: weekdays,
+	}
+	for _, s := range amounts {
+		d.amounts[amountKey{currency: s.Currency, debit: s.Debit}] = s
+	}
+	for _, n := range weekdays {
+		d.history += n
+	}
+	return d
+}
+
+// Flag returns the FlagAmount and FlagWeekday flags of an imported transaction, comma separated,
+// or an empty string when it looks normal. Duplicates are flagged by FlagDuplicates once the rows
+// are about to be stored. A nil Detector flags nothing.
+func (d *Detector) Flag(date time.Time, amount money.Amount, currency string) string {
+	if d == nil {
+		return ""
+	}
+
+	var flags []string
+	if d.unusualAmount(amount, currency) {
+		flags = append(flags, FlagAmount)
+	}
+	if d.unusualWeekday(date.Weekday()) {
+		flags = append(flags, FlagWeekday)
+	}
+	return strings.Join(flags, ",")
+}
+
+// unusualAmount reports whether an amount is more than maxZScore standard deviations from the
+// mean of the amounts in the same currency and direction. Groups with fewer than minHistory
+// amounts, or all of them equal, can't tell.
+func (d *Detector) unusualAmount(amount money.Amount, currency string) bool {
+	s, ok := d.amounts[amountKey{currency: currency, debit: amount < 0}]
+	if !ok || s.Count < minHistory || s.StdDev == 0 {
+		return false
+	}
+	return math.Abs(float64(amount)-s.Mean)/s.StdDev > maxZScore
+}
+
+// unusualWeekday reports whether fewer than minWeekdayShare percent of the history fell on the
+// weekday.
+func (d *Detector) unusualWeekday(weekday time.Weekday) bool {
+	if d.history < minHistory {
+		return false
+	}
+	return d.weekdays[weekday]*100 < d.history*minWeekdayShare
+}
+
+// addFlag adds a flag to a comma separated list of flags, unless it is already there.
+func addFlag(flags, flag string) string {
+	if flags == "" {
+		return flag
+	}
+	if slices.Contains(strings.Split(flags, ","), flag) {
+		return flags
+	}
+	return flags + "," + flag
+}
+
+// flagDuplicates flags the documents repeating the date, amount and currency of a ledger row, or of
+// an earlier document, with a different IdTransaction. Rows about to be overwritten don't count as
+// duplicates of themselves.
+func flagDuplicates(docs []models.SQLDocument, ledger []ledgerRow) {
+	stored := map[duplicateKey][]uint{}
+	for _, l := range ledger {
+		key := duplicateKey{date: l.Date.Format(dateLayout), amount: l.Amount, currency: l.Currency}
+		stored[key] = append(stored[key], l.IdTransaction)
+	}
+
+	for i := range docs {
+		key := duplicateKey{date: docs[i].Date, amount: docs[i].Transaction, currency: docs[i].Currency}
+		for _, id := range stored[key] {
+			if id != docs[i].IdTransaction {
+				docs[i].Anomalies = addFlag(docs[i].Anomalies, FlagDuplicate)
+				break
+			}
+		}
+		stored[key] = append(stored[key], docs[i].IdTransaction)
+	}
+}
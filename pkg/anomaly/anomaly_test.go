@@ -0,0 +1,81 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+)
+
+// history returns a Detector for an account paying about 50.00 USD on weekdays, almost never on
+// Saturdays and never on Sundays, with only a few credits.
+func history() *Detector {
+	amounts := []amountStats{
+		{Currency: "USD", Debit: true, Count: 50, Mean: -5000, StdDev: 1000},
+		{Currency: "USD", Debit: false, Count: 2, Mean: 250000, StdDev: 10},
+	}
+	weekdays := [7]int{time.Monday: 10, time.Tuesday: 10, time.Wednesday: 10, time.Thursday: 10, time.Friday: 10, time.Saturday: 1}
+	return newDetector(amounts, weekdays)
+}
+
+// List of transactions with the flags they are expected to get
+var flagTests = []struct {
+	date     time.Time    // Date of the transaction
+	amount   money.Amount // Amount of the transaction
+	currency string       // Currency of the amount
+	expected string       // Expected flags
+}{
+	{time.Date(2024, time.August, 5, 0, 0, 0, 0, time.UTC), -6500, "USD", ""},                 // Monday, 1.5 deviations
+	{time.Date(2024, time.August, 5, 0, 0, 0, 0, time.UTC), -8000, "USD", ""},                 // Monday, exactly the limit
+	{time.Date(2024, time.August, 6, 0, 0, 0, 0, time.UTC), -95000, "USD", "amount"},          // Tuesday, 90 deviations
+	{time.Date(2024, time.August, 6, 0, 0, 0, 0, time.UTC), 100, "USD", ""},                   // Too few credits to tell
+	{time.Date(2024, time.August, 6, 0, 0, 0, 0, time.UTC), -95000, "MXN", ""},                // No history in the currency
+	{time.Date(2024, time.August, 10, 0, 0, 0, 0, time.UTC), -5000, "USD", "weekday"},         // Saturday
+	{time.Date(2024, time.August, 11, 0, 0, 0, 0, time.UTC), -95000, "USD", "amount,weekday"}, // Sunday
+}
+
+// TestFlag tests that amounts and weekdays far from the history are flagged
+func TestFlag(t *testing.T) {
+	d := history()
+	for _, test := range flagTests {
+		if got := d.Flag(test.date, test.amount, test.currency); got != test.expected {
+			t.Errorf("Flag(%s, %d, %s) = %q, expected %q", test.date.Format(dateLayout), test.amount, test.currency, got, test.expected)
+		}
+	}
+
+	// New accounts have no history to compare with
+	sunday := time.Date(2024, time.August, 11, 0, 0, 0, 0, time.UTC)
+	if got := newDetector(nil, [7]int{time.Monday: 5}).Flag(sunday, -95000, "USD"); got != "" {
+		t.Errorf("expected no flags without history, got %q", got)
+	}
+	var none *Detector
+	if got := none.Flag(sunday, -95000, "USD"); got != "" {
+		t.Errorf("expected no flags from a nil Detector, got %q", got)
+	}
+}
+
+// TestFlagDuplicates tests that rows repeating a stored or earlier row are flagged as duplicates
+func TestFlagDuplicates(t *testing.T) {
+	date := time.Date(2024, time.August, 5, 0, 0, 0, 0, time.UTC)
+	ledger := []ledgerRow{
+		{IdTransaction: 1, Date: date, Amount: -5000, Currency: "USD"},
+		{IdTransaction: 2, Date: date, Amount: -1200, Currency: "USD"},
+	}
+	docs := []models.SQLDocument{
+		{IdTransaction: 3, Date: "2024-08-05", Transaction: -5000, Currency: "USD"},                      // Same as 1
+		{IdTransaction: 2, Date: "2024-08-05", Transaction: -1200, Currency: "USD"},                      // Overwrites 2
+		{IdTransaction: 4, Date: "2024-08-05", Transaction: -5000, Currency: "MXN"},                      // Other currency
+		{IdTransaction: 5, Date: "2024-08-06", Transaction: -700, Currency: "USD", Anomalies: "weekday"}, // First of its kind
+		{IdTransaction: 6, Date: "2024-08-06", Transaction: -700, Currency: "USD", Anomalies: "weekday"}, // Same as 5
+	}
+
+	flagDuplicates(docs, ledger)
+
+	expected := []string{"duplicate", "", "", "weekday", "weekday,duplicate"}
+	for i, doc := range docs {
+		if doc.Anomalies != expected[i] {
+			t.Errorf("transaction %d flagged %q, expected %q", doc.IdTransaction, doc.Anomalies, expected[i])
+		}
+	}
+}
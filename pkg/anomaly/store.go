@@ -0,0 +1,112 @@
+package anomaly
+
+import (
+	"fmt"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/imports"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxReported is the number of flagged transactions ForBatch returns.
+const maxReported = 20
+
+// ForAccount creates the Detector of an account from its current ledger.
+func ForAccount(accountId uint) (*Detector, error) {
+	ledger := config.GetDB().Model(&models.SQLDocument{}).Where("account_id = ?", accountId)
+
+	var amounts []amountStats
+	err := ledger.Session(&gorm.Session{}).
+		Select("currency, amount_cents < 0 AS debit, COUNT(*) AS count, AVG(amount_cents) AS mean, STDDEV_POP(amount_cents) AS std_dev").
+		Group("currency, debit").
+		Scan(&amounts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the amounts of account %d: %w", accountId, err)
+	}
+
+	var rows []struct {
+		Weekday int // Day of the week, 0 being Sunday
+		Count   int // Transactions on that day
+	}
+	err = ledger.Session(&gorm.Session{}).
+		Select("DAYOFWEEK(date) - 1 AS weekday, COUNT(*) AS count").
+		Group("weekday").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the weekdays of account %d: %w", accountId, err)
+	}
+
+	var weekdays [7]int
+	for _, r := range rows {
+		weekdays[r.Weekday] = r.Count
+	}
+	return newDetector(amounts, weekdays), nil
+}
+
+// FlagDuplicates flags the documents about to be stored in the ledger of an account that repeat
+// the date, amount and currency of a stored transaction or of another document. It runs on tx so
+// the rows stored earlier in the same import are compared too.
+func FlagDuplicates(tx *gorm.DB, accountId uint, docs []models.SQLDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	dates := make([]string, len(docs))
+	amounts := make([]money.Amount, len(docs))
+	for i, doc := range docs {
+		dates[i] = doc.Date
+		amounts[i] = doc.Transaction
+	}
+
+	var ledger []ledgerRow
+	err := tx.Model(&models.SQLDocument{}).
+		Select("id_transaction, date, amount_cents AS amount, currency").
+		Where("account_id = ? AND date IN ? AND amount_cents IN ?", accountId, dates, amounts).
+		Scan(&ledger).Error
+	if err != nil {
+		return fmt.Errorf("failed to find duplicates in account %d: %w", accountId, err)
+	}
+
+	flagDuplicates(docs, ledger)
+	return nil
+}
+
+// ForBatch returns the first maxReported transactions flagged when the given import stored them,
+// oldest first.
+func ForBatch(accountId, importBatchId uint) ([]models.FlaggedTransaction, error) {
+	var rows []struct {
+		IdTransaction uint
+		Date          time.Time
+		Description   string
+		Amount        money.Amount
+		Currency      string
+		Anomalies     string
+	}
+	err := config.GetDB().Model(&models.SQLDocument{}).
+		Select("id_transaction, date, description, amount_cents AS amount, currency, anomalies").
+		Where("account_id = ? AND anomalies <> ''", accountId).
+		Scopes(imports.Rows(importBatchId)).
+		Order("date, id_transaction").
+		Limit(maxReported).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the flagged transactions of import %d: %w", importBatchId, err)
+	}
+
+	flagged := make([]models.FlaggedTransaction, len(rows))
+	for i, r := range rows {
+		flagged[i] = models.FlaggedTransaction{
+			Date:          r.Date.Format(dateLayout),
+			IdTransaction: r.IdTransaction,
+			Description:   r.Description,
+			Amount:        r.Amount,
+			Currency:      r.Currency,
+			Flags:         strings.Split(r.Anomalies, ","),
+		}
+	}
+	return flagged, nil
+}
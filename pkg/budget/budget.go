@@ -0,0 +1,131 @@
+package budget
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"stori_challenge/pkg/category"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidBudget is returned when a budget has no positive limit or its category is too long.
+	ErrInvalidBudget = errors.New("invalid budget")
+	// ErrDuplicateBudget is returned when an account already has a budget for the category.
+	ErrDuplicateBudget = errors.New("budget already exists for the category")
+)
+
+type (
+	// spendKey identifies the spending of a month in one category.
+	spendKey struct {
+		Year     int        // Year of the transactions
+		Month    time.Month // Month of the transactions
+		Category string     // Category of the transactions, empty for uncategorized ones
+	}
+
+	// monthKey identifies a month.
+	monthKey struct {
+		Year  int        // Year of the month
+		Month time.Month // Month of the year
+	}
+
+	// spending maps each month and category to the amount spent, as a positive amount in the
+	// account currency.
+	spending map[spendKey]money.Amount
+)
+
+// Validate normalizes a budget and checks that it has a positive limit and a category that fits
+// in the category column.
+func Validate(budget *models.Budget) error {
+	budget.Category = strings.TrimSpace(budget.Category)
+	if utf8.RuneCountInString(budget.Category) > category.MaxCategoryLength {
+		return fmt.Errorf("%w: category longer than %d characters", ErrInvalidBudget, category.MaxCategoryLength)
+	}
+	if budget.Limit <= 0 {
+		return fmt.Errorf("%w: the limit must be greater than zero", ErrInvalidBudget)
+	}
+	return nil
+}
+
+// evaluate compares the spending of the months an import added transactions to with the budgets
+// of the account. A budget raises an alert for a month when the spending after the import is over
+// its limit while the spending before it was not, so each month is reported once, by the import
+// that crossed the limit.
+func evaluate(budgets []models.Budget, total, added spending) []models.BudgetAlert {
+	// Months the import added spending to, in chronological order
+	var months []monthKey
+	seen := map[monthKey]bool{}
+	for key := range added {
+		month := monthKey{Year: key.Year, Month: key.Month}
+		if !seen[month] {
+			seen[month] = true
+			months = append(months, month)
+		}
+	}
+	slices.SortFunc(months, func(a, b monthKey) int {
+		if c := cmp.Compare(a.Year, b.Year); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Month, b.Month)
+	})
+
+	alerts := []models.BudgetAlert{}
+	for _, month := range months {
+		for _, b := range budgets {
+			after := total.in(month, b.Category)
+			before := after - added.in(month, b.Category)
+			if after <= b.Limit || before > b.Limit {
+				continue // Within the budget, or already over it before the import
+			}
+
+			alerts = append(alerts, models.BudgetAlert{
+				AccountId: b.AccountId,
+				BudgetId:  b.Id,
+				Category:  b.Category,
+				Year:      month.Year,
+				Month:     month.Month,
+				Limit:     b.Limit,
+				Spent:     after,
+			})
+		}
+	}
+	return alerts
+}
+
+// in returns the spending of a month in a category, or in every category when category is empty.
+func (s spending) in(month monthKey, category string) money.Amount {
+	if category != "" {
+		return s[spendKey{Year: month.Year, Month: month.Month, Category: category}]
+	}
+
+	var spent money.Amount
+	for key, amount := range s {
+		if key.Year == month.Year && key.Month == month.Month {
+			spent += amount
+		}
+	}
+	return spent
+}
+
+// minus returns the spending of s less that of other, which may be negative, leaving out the
+// months and categories where they are equal.
+func (s spending) minus(other spending) spending {
+	diff := spending{}
+	for key, amount := range s {
+		diff[key] += amount
+	}
+	for key, amount := range other {
+		diff[key] -= amount
+	}
+	for key, amount := range diff {
+		if amount == 0 {
+			delete(diff, key)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,106 @@
+package budget
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"stori_challenge/pkg/category"
+	"stori_challenge/pkg/models"
+)
+
+// List of budgets with whether they are valid
+var validateTests = []struct {
+	budget models.Budget // Budget as received in the request
+	valid  bool          // Indicates if the budget is expected to be valid
+}{
+	{models.Budget{Category: "Groceries", Limit: 40000}, true},
+	{models.Budget{Category: "", Limit: 150000}, true}, // All spending
+	{models.Budget{Category: "Groceries", Limit: 0}, false},
+	{models.Budget{Category: "Groceries", Limit: -100}, false},
+	{models.Budget{Category: strings.Repeat("x", category.MaxCategoryLength+1), Limit: 100}, false},
+}
+
+// TestValidate tests the validation of budgets
+func TestValidate(t *testing.T) {
+	for _, test := range validateTests {
+		budget := test.budget
+		err := Validate(&budget)
+		if (err == nil) != test.valid {
+			t.Errorf("For %+v expected valid: %v, got error: %v", test.budget, test.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidBudget) {
+			t.Errorf("For %+v expected ErrInvalidBudget, got: %v", test.budget, err)
+		}
+	}
+}
+
+// TestEvaluate tests that alerts are raised only for the months an import pushed over a budget
+func TestEvaluate(t *testing.T) {
+	budgets := []models.Budget{
+		{Id: 1, AccountId: 7, Category: "Groceries", Limit: 40000},
+		{Id: 2, AccountId: 7, Category: "", Limit: 130000}, // All spending
+		{Id: 3, AccountId: 7, Category: "Rent", Limit: 80000},
+	}
+
+	// Spending of the ledger after the import
+	total := spending{
+		{2024, time.July, "Groceries"}:   45000, // Over budget, 10000 before the import
+		{2024, time.July, ""}:            30000,
+		{2024, time.August, "Groceries"}: 50000, // Already over budget before the import
+		{2024, time.August, "Rent"}:      70000, // Within budget
+		{2024, time.August, "Travel"}:    20000, // Pushes all spending over budget, 120000 before the import
+		{2024, time.June, "Groceries"}:   30000, // Not touched by the import
+	}
+	// Spending added by the import
+	added := spending{
+		{2024, time.August, "Groceries"}: 5000,
+		{2024, time.August, "Travel"}:    15000,
+		{2024, time.July, "Groceries"}:   35000,
+		{2024, time.July, ""}:            30000,
+	}
+
+	expected := []models.BudgetAlert{
+		{AccountId: 7, BudgetId: 1, Category: "Groceries", Year: 2024, Month: time.July, Limit: 40000, Spent: 45000},
+		{AccountId: 7, BudgetId: 2, Category: "", Year: 2024, Month: time.August, Limit: 130000, Spent: 140000},
+	}
+	if alerts := evaluate(budgets, total, added); !reflect.DeepEqual(alerts, expected) {
+		t.Errorf("evaluate() = %+v, expected %+v", alerts, expected)
+	}
+
+	// Nothing added, nothing to report
+	if alerts := evaluate(budgets, total, spending{}); len(alerts) != 0 {
+		t.Errorf("expected no alerts, got %+v", alerts)
+	}
+}
+
+// TestSpendingMinus tests that overwritten rows only count for the difference with their previous values
+func TestSpendingMinus(t *testing.T) {
+	// Spending of the rows stored by an import, and of the values they overwrote
+	stored := spending{
+		{2024, time.July, "Groceries"}: 45000, // Unchanged
+		{2024, time.July, "Rent"}:      80000, // 5000 more than before
+		{2024, time.July, "Travel"}:    20000, // New
+	}
+	previous := spending{
+		{2024, time.July, "Groceries"}: 45000,
+		{2024, time.July, "Rent"}:      75000,
+		{2024, time.June, "Rent"}:      10000, // Moved to another month
+	}
+
+	expected := spending{
+		{2024, time.July, "Rent"}:   5000,
+		{2024, time.July, "Travel"}: 20000,
+		{2024, time.June, "Rent"}:   -10000,
+	}
+	if diff := stored.minus(previous); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("minus() = %v, expected %v", diff, expected)
+	}
+
+	// Uploading the same rows again adds nothing
+	if diff := stored.minus(stored); len(diff) != 0 {
+		t.Errorf("expected no spending, got %v", diff)
+	}
+}
@@ -0,0 +1,190 @@
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/exchange"
+	"stori_challenge/pkg/imports"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ListBudgets returns the budgets of an account ordered by category.
+func ListBudgets(accountId uint) ([]models.Budget, error) {
+	budgets := []models.Budget{}
+	if err := config.GetDB().Where("account_id = ?", accountId).Order("category").Find(&budgets).Error; err != nil {
+		return nil, fmt.Errorf("failed to list budgets of account %d: %w", accountId, err)
+	}
+	return budgets, nil
+}
+
+// GetBudget retrieves a budget of an account by its primary key.
+func GetBudget(accountId, id uint) (models.Budget, error) {
+	var budget models.Budget
+	if err := config.GetDB().Where("account_id = ?", accountId).First(&budget, id).Error; err != nil {
+		return models.Budget{}, fmt.Errorf("failed to get budget %d: %w", id, err)
+	}
+	return budget, nil
+}
+
+// CreateBudget validates and stores a new budget, refusing a second budget for the same category.
+func CreateBudget(budget *models.Budget) error {
+	if err := Validate(budget); err != nil {
+		return err
+	}
+	if err := checkDuplicate(budget); err != nil {
+		return err
+	}
+	if err := config.GetDB().Create(budget).Error; err != nil {
+		return fmt.Errorf("failed to create budget: %w", err)
+	}
+	return nil
+}
+
+// UpdateBudget validates and stores the changes of an existing budget.
+func UpdateBudget(budget *models.Budget) error {
+	if err := Validate(budget); err != nil {
+		return err
+	}
+	if err := checkDuplicate(budget); err != nil {
+		return err
+	}
+	if err := config.GetDB().Save(budget).Error; err != nil {
+		return fmt.Errorf("failed to update budget %d: %w", budget.Id, err)
+	}
+	return nil
+}
+
+// DeleteBudget removes a budget of an account. Alerts it raised are kept.
+func DeleteBudget(accountId, id uint) error {
+	result := config.GetDB().Where("account_id = ?", accountId).Delete(&models.Budget{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete budget %d: %w", id, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("failed to delete budget %d: %w", id, gorm.ErrRecordNotFound)
+	}
+	return nil
+}
+
+// ListAlerts returns the budget alerts of an account, newest first, optionally only those
+// raised by one import.
+func ListAlerts(accountId, importBatchId uint) ([]models.BudgetAlert, error) {
+	query := config.GetDB().Where("account_id = ?", accountId)
+	if importBatchId != 0 {
+		query = query.Where("import_batch_id = ?", importBatchId)
+	}
+
+	alerts := []models.BudgetAlert{}
+	if err := query.Order("id DESC").Find(&alerts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list budget alerts of account %d: %w", accountId, err)
+	}
+	return alerts, nil
+}
+
+// Check compares the transactions added by an import with the budgets of the account and records
+// an alert for every month the import pushed over a budget. Spending in other currencies is
+// converted into the account currency with rates. Rows the import overwrote only count for the
+// difference with their previous values, so uploading an unchanged file again adds no spending.
+func Check(accountId, importBatchId uint, currency string, rates exchange.RateProvider) ([]models.BudgetAlert, error) {
+	budgets, err := ListBudgets(accountId)
+	if err != nil || len(budgets) == 0 {
+		return []models.BudgetAlert{}, err
+	}
+
+	// A new session lets both queries below start from the account's ledger
+	ledger := config.GetDB().Model(&models.SQLDocument{}).Where("account_id = ?", accountId).Session(&gorm.Session{})
+	stored, err := monthlySpending(ledger.Scopes(imports.Rows(importBatchId)), rates, currency)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := monthlySpending(config.GetDB().Model(&models.OverwrittenRow{}).Where("import_batch_id = ?", importBatchId), rates, currency)
+	if err != nil {
+		return nil, err
+	}
+	added := stored.minus(previous)
+	if len(added) == 0 {
+		return []models.BudgetAlert{}, nil // The import added no spending
+	}
+
+	// Spending of the whole ledger in the months the import touched
+	var first, last time.Time
+	for key := range added {
+		month := time.Date(key.Year, key.Month, 1, 0, 0, 0, 0, time.UTC)
+		if first.IsZero() || month.Before(first) {
+			first = month
+		}
+		if month.After(last) {
+			last = month
+		}
+	}
+	total, err := monthlySpending(ledger.
+		Where("date >= ? AND date < ?", first.Format("2006-01-02"), last.AddDate(0, 1, 0).Format("2006-01-02")), rates, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := evaluate(budgets, total, added)
+	for i := range alerts {
+		alerts[i].ImportBatchId = importBatchId
+	}
+	if len(alerts) > 0 {
+		if err := config.GetDB().Create(&alerts).Error; err != nil {
+			return nil, fmt.Errorf("failed to record budget alerts: %w", err)
+		}
+	}
+	return alerts, nil
+}
+
+// checkDuplicate returns ErrDuplicateBudget when another budget of the account has the same category.
+func checkDuplicate(budget *models.Budget) error {
+	var existing models.Budget
+	err := config.GetDB().
+		Where("account_id = ? AND category = ? AND id <> ?", budget.AccountId, budget.Category, budget.Id).
+		First(&existing).Error
+	if err == nil {
+		return ErrDuplicateBudget
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to look up budgets: %w", err)
+	}
+	return nil
+}
+
+// monthlySpending sums the debits selected by query per month and category, converted into the
+// account currency.
+func monthlySpending(query *gorm.DB, rates exchange.RateProvider, currency string) (spending, error) {
+	var rows []struct {
+		Year       int
+		Month      time.Month
+		Category   string
+		Currency   string
+		DebitTotal money.Amount
+	}
+	err := query.
+		Select("YEAR(date) AS year, MONTH(date) AS month, category, currency, SUM(amount_cents) AS debit_total").
+		Where("amount_cents < 0").
+		Group("YEAR(date), MONTH(date), category, currency").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly spending: %w", err)
+	}
+
+	spent := spending{}
+	for _, r := range rows {
+		from := r.Currency
+		if from == "" {
+			from = currency // Rows imported before currencies were tracked
+		}
+		debit, err := exchange.Convert(rates, r.DebitTotal, from, currency)
+		if err != nil {
+			return nil, fmt.Errorf("error converting %s spending: %w", from, err)
+		}
+		spent[spendKey{Year: r.Year, Month: r.Month, Category: r.Category}] -= debit // Debits are negative
+	}
+	return spent, nil
+}
@@ -0,0 +1,149 @@
+package category
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kinds of CategoryRule.
+const (
+	KindKeyword = "keyword" // The description contains the pattern, ignoring case
+	KindRegex   = "regex"   // The description matches the pattern, a regular expression ignoring case
+	KindAmount  = "amount"  // The amount is within the rule's range; the description is ignored
+)
+
+// MaxCategoryLength is the size of the category columns.
+const MaxCategoryLength = 64
+
+// ErrInvalidRule is returned when a category rule can't be used to categorize transactions.
+var ErrInvalidRule = errors.New("invalid category rule")
+
+type (
+	// Categorizer assigns categories to transactions with the rules of an account.
+	Categorizer struct {
+		rules []compiledRule // Rules in the order they are tried
+	}
+
+	// compiledRule is a CategoryRule ready to be matched.
+	compiledRule struct {
+		rule    models.CategoryRule // Rule as stored
+		keyword string              // Lower case pattern of keyword rules
+		regex   *regexp.Regexp      // Compiled pattern of regex rules
+	}
+)
+
+// Validate normalizes a rule and checks that it can be used: it needs a category, a known kind,
+// a pattern for keyword and regex rules, and a range whose bounds are in order. Amount ranges
+// also restrict keyword and regex rules.
+func Validate(rule *models.CategoryRule) error {
+	rule.Category = strings.TrimSpace(rule.Category)
+	rule.Kind = strings.ToLower(strings.TrimSpace(rule.Kind))
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+
+	if rule.Category == "" {
+		return fmt.Errorf("%w: missing category", ErrInvalidRule)
+	}
+	if utf8.RuneCountInString(rule.Category) > MaxCategoryLength {
+		return fmt.Errorf("%w: category longer than %d characters", ErrInvalidRule, MaxCategoryLength)
+	}
+
+	switch rule.Kind {
+	case KindKeyword, KindRegex:
+		if rule.Pattern == "" {
+			return fmt.Errorf("%w: %s rules need a pattern", ErrInvalidRule, rule.Kind)
+		}
+	case KindAmount:
+		if rule.MinAmount == nil && rule.MaxAmount == nil {
+			return fmt.Errorf("%w: amount rules need minAmount or maxAmount", ErrInvalidRule)
+		}
+		rule.Pattern = "" // Not used
+	default:
+		return fmt.Errorf("%w: unknown kind %q, expected keyword, regex or amount", ErrInvalidRule, rule.Kind)
+	}
+
+	if rule.MinAmount != nil && rule.MaxAmount != nil && *rule.MinAmount > *rule.MaxAmount {
+		return fmt.Errorf("%w: minAmount is greater than maxAmount", ErrInvalidRule)
+	}
+	_, err := compile(*rule)
+	return err
+}
+
+// New creates a Categorizer from the rules of an account, trying them by ascending priority
+// and, for equal priorities, in the order they were created.
+func New(rules []models.CategoryRule) (*Categorizer, error) {
+	sorted := make([]models.CategoryRule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		return sorted[i].Id < sorted[j].Id
+	})
+
+	c := &Categorizer{rules: make([]compiledRule, 0, len(sorted))}
+	for _, rule := range sorted {
+		compiled, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", rule.Id, err)
+		}
+		c.rules = append(c.rules, compiled)
+	}
+	return c, nil
+}
+
+// Categorize returns the category of the first rule matching a transaction, or an empty string
+// when none does. A nil Categorizer leaves every transaction uncategorized.
+func (c *Categorizer) Categorize(description string, amount money.Amount) string {
+	if c == nil {
+		return ""
+	}
+
+	lower := strings.ToLower(description)
+	for _, r := range c.rules {
+		if r.matches(description, lower, amount) {
+			return r.rule.Category
+		}
+	}
+	return ""
+}
+
+// compile prepares a rule to be matched.
+func compile(rule models.CategoryRule) (compiledRule, error) {
+	compiled := compiledRule{rule: rule}
+	switch rule.Kind {
+	case KindKeyword:
+		compiled.keyword = strings.ToLower(rule.Pattern)
+	case KindRegex:
+		regex, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return compiledRule{}, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+		compiled.regex = regex
+	}
+	return compiled, nil
+}
+
+// matches reports whether a transaction matches the rule; lower is the description in lower case.
+func (r compiledRule) matches(description, lower string, amount money.Amount) bool {
+	if r.rule.MinAmount != nil && amount < *r.rule.MinAmount {
+		return false
+	}
+	if r.rule.MaxAmount != nil && amount > *r.rule.MaxAmount {
+		return false
+	}
+
+	switch r.rule.Kind {
+	case KindKeyword:
+		return strings.Contains(lower, r.keyword)
+	case KindRegex:
+		return r.regex.MatchString(description)
+	default:
+		return true // Amount rules only look at the range
+	}
+}
@@ -0,0 +1,87 @@
+package category
+
+import (
+	"errors"
+	"testing"
+
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+)
+
+// amount returns a pointer to an amount in cents, for the bounds of amount ranges.
+func amount(cents int64) *money.Amount {
+	a := money.Amount(cents)
+	return &a
+}
+
+// List of rules with whether they are valid
+var validateTests = []struct {
+	rule  models.CategoryRule // Rule as received in the request
+	valid bool                // Indicates if the rule is expected to be valid
+}{
+	{models.CategoryRule{Category: "Groceries", Kind: "keyword", Pattern: "market"}, true},
+	{models.CategoryRule{Category: "Rides", Kind: " REGEX ", Pattern: `^(uber|lyft)\b`}, true},
+	{models.CategoryRule{Category: "Small", Kind: "amount", MinAmount: amount(-1000), MaxAmount: amount(0)}, true},
+	{models.CategoryRule{Category: "Income", Kind: "amount", MinAmount: amount(1)}, true},
+	{models.CategoryRule{Category: "Coffee", Kind: "keyword", Pattern: "cafe", MaxAmount: amount(-100)}, true},
+	{models.CategoryRule{Category: " ", Kind: "keyword", Pattern: "market"}, false},  // Missing category
+	{models.CategoryRule{Category: "Groceries", Kind: "keyword"}, false},             // Missing pattern
+	{models.CategoryRule{Category: "Rides", Kind: "regex", Pattern: "(uber"}, false}, // Invalid expression
+	{models.CategoryRule{Category: "Small", Kind: "amount"}, false},                  // Missing range
+	{models.CategoryRule{Category: "Small", Kind: "amount", MinAmount: amount(5), MaxAmount: amount(1)}, false},
+	{models.CategoryRule{Category: "Groceries", Kind: "merchant", Pattern: "market"}, false}, // Unknown kind
+}
+
+// TestValidate tests the validation of category rules
+func TestValidate(t *testing.T) {
+	for _, test := range validateTests {
+		rule := test.rule
+		err := Validate(&rule)
+		if (err == nil) != test.valid {
+			t.Errorf("For %+v expected valid: %v, got error: %v", test.rule, test.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("For %+v expected ErrInvalidRule, got: %v", test.rule, err)
+		}
+	}
+}
+
+// TestCategorize tests that the first matching rule by priority assigns the category
+func TestCategorize(t *testing.T) {
+	categorizer, err := New([]models.CategoryRule{
+		{Id: 1, Category: "Shopping", Kind: KindKeyword, Pattern: "MARKET", Priority: 2},
+		{Id: 2, Category: "Groceries", Kind: KindKeyword, Pattern: "super market", Priority: 1},
+		{Id: 3, Category: "Rides", Kind: KindRegex, Pattern: `^(uber|lyft)\b`, Priority: 1},
+		{Id: 4, Category: "Salary", Kind: KindAmount, MinAmount: amount(100000), Priority: 3},
+		{Id: 5, Category: "Coffee", Kind: KindKeyword, Pattern: "cafe", MinAmount: amount(-1000), MaxAmount: amount(-1), Priority: 3},
+	})
+	if err != nil {
+		t.Fatalf("New: unexpected error %v", err)
+	}
+
+	tests := []struct {
+		description string       // Description of the transaction
+		amount      money.Amount // Amount of the transaction
+		expected    string       // Expected category
+	}{
+		{"Super Market #12", -4550, "Groceries"}, // Priority 1 wins over priority 2
+		{"Flea market", -1200, "Shopping"},
+		{"UBER *TRIP", -1500, "Rides"},
+		{"Trip with Uber", -1500, ""}, // Anchored expression
+		{"ACME Payroll", 250000, "Salary"},
+		{"Cafe Central", -450, "Coffee"},
+		{"Cafe Central", -4500, ""}, // Outside the range of the rule
+		{"", 500, ""},
+	}
+	for _, test := range tests {
+		if got := categorizer.Categorize(test.description, test.amount); got != test.expected {
+			t.Errorf("Categorize(%q, %s) = %q, expected %q", test.description, test.amount, got, test.expected)
+		}
+	}
+
+	// Without rules nothing is categorized
+	var none *Categorizer
+	if got := none.Categorize("Super Market", -100); got != "" {
+		t.Errorf("nil Categorizer returned %q", got)
+	}
+}
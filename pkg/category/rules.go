@@ -0,0 +1,117 @@
+package category
+
+import (
+	"fmt"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/models"
+
+	"gorm.io/gorm"
+)
+
+// recategorizeBatchSize is the number of ledger rows read at a time by Recategorize.
+const recategorizeBatchSize = 1000
+
+// ListRules returns the category rules of an account in the order they are tried.
+func ListRules(accountId uint) ([]models.CategoryRule, error) {
+	rules := []models.CategoryRule{}
+	err := config.GetDB().
+		Where("account_id = ?", accountId).
+		Order("priority, id").
+		Find(&rules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list category rules of account %d: %w", accountId, err)
+	}
+	return rules, nil
+}
+
+// GetRule retrieves a category rule of an account by its primary key.
+func GetRule(accountId, id uint) (models.CategoryRule, error) {
+	var rule models.CategoryRule
+	if err := config.GetDB().Where("account_id = ?", accountId).First(&rule, id).Error; err != nil {
+		return models.CategoryRule{}, fmt.Errorf("failed to get category rule %d: %w", id, err)
+	}
+	return rule, nil
+}
+
+// CreateRule validates and stores a new category rule.
+func CreateRule(rule *models.CategoryRule) error {
+	if err := Validate(rule); err != nil {
+		return err
+	}
+	if err := config.GetDB().Create(rule).Error; err != nil {
+		return fmt.Errorf("failed to create category rule: %w", err)
+	}
+	return nil
+}
+
+// UpdateRule validates and stores the changes of an existing category rule.
+func UpdateRule(rule *models.CategoryRule) error {
+	if err := Validate(rule); err != nil {
+		return err
+	}
+	if err := config.GetDB().Save(rule).Error; err != nil {
+		return fmt.Errorf("failed to update category rule %d: %w", rule.Id, err)
+	}
+	return nil
+}
+
+// DeleteRule removes a category rule of an account.
+func DeleteRule(accountId, id uint) error {
+	result := config.GetDB().Where("account_id = ?", accountId).Delete(&models.CategoryRule{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete category rule %d: %w", id, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("failed to delete category rule %d: %w", id, gorm.ErrRecordNotFound)
+	}
+	return nil
+}
+
+// ForAccount returns the Categorizer built from the current rules of an account.
+func ForAccount(accountId uint) (*Categorizer, error) {
+	rules, err := ListRules(accountId)
+	if err != nil {
+		return nil, err
+	}
+	return New(rules)
+}
+
+// Recategorize applies the current rules of an account to every transaction of its ledger, so
+// rule changes also reach transactions imported before them. It returns the number of
+// transactions whose category changed.
+func Recategorize(accountId uint) (int64, error) {
+	categorizer, err := ForAccount(accountId)
+	if err != nil {
+		return 0, err
+	}
+
+	var changed int64
+	var rows []models.SQLDocument
+	err = config.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.SQLDocument{}).
+			Select("id, description, amount_cents, category").
+			Where("account_id = ?", accountId).
+			FindInBatches(&rows, recategorizeBatchSize, func(batch *gorm.DB, _ int) error {
+				// One UPDATE per category that changed in the batch
+				ids := map[string][]uint{}
+				for _, row := range rows {
+					if category := categorizer.Categorize(row.Description, row.Transaction); category != row.Category {
+						ids[category] = append(ids[category], row.Id)
+					}
+				}
+				for category, list := range ids {
+					err := tx.Model(&models.SQLDocument{}).Where("id IN ?", list).Update("category", category).Error
+					if err != nil {
+						return err
+					}
+					changed += int64(len(list))
+				}
+				return nil
+			})
+		return result.Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to recategorize the ledger of account %d: %w", accountId, err)
+	}
+	return changed, nil
+}
@@ -5,7 +5,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"stori_challenge/pkg/models"
 	"sync"
 	"time"
 
@@ -37,8 +36,7 @@ func GetDB() *gorm.DB {
 		}
 
 		// Automatically migrate the schema to keep the database in sync with the models
-		err = db.AutoMigrate(&models.SQLDocument{})
-		if err != nil {
+		if err := migrateSchema(db); err != nil {
 			log.Fatalf("Error migrating schema: %v", err)
 		}
 
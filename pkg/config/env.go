@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnvInt reads a non-negative integer setting from the environment, using def when it is unset.
+func GetEnvInt(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %s", name, value)
+	}
+	return n, nil
+}
+
+// GetEnvDuration reads a positive duration setting such as "30s" from the environment, using def when it is unset.
+func GetEnvDuration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid value for %s: %s", name, value)
+	}
+	return d, nil
+}
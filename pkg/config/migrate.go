@@ -0,0 +1,119 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"stori_challenge/pkg/models"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// migrateSchema brings the database up to date with the models. Ledgers stored by earlier
+// versions of the service are converted first, since AutoMigrate only adds the new columns.
+func migrateSchema(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.Account{}); err != nil {
+		return err
+	}
+	if err := migrateAccounts(db); err != nil {
+		return err
+	}
+	if err := migrateAmounts(db); err != nil {
+		return err
+	}
+	return db.AutoMigrate(&models.Account{}, &models.Budget{}, &models.BudgetAlert{}, &models.CategoryRule{}, &models.ImportBatch{}, &models.ImportJob{}, &models.OutboxEmail{}, &models.OverwrittenRow{}, &models.SQLDocument{})
+}
+
+// migrateAccounts assigns the transactions stored before ledgers belonged to accounts to the
+// account of SMTP_EMAIL_TO, the recipient of the summaries back then, creating it if needed.
+// Without it they would be left out of every summary.
+func migrateAccounts(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&models.SQLDocument{}) {
+		return nil // New database, created by AutoMigrate
+	}
+	if !m.HasColumn(&models.SQLDocument{}, "account_id") {
+		if err := m.AddColumn(&models.SQLDocument{}, "AccountId"); err != nil {
+			return fmt.Errorf("failed to add the account_id column: %w", err)
+		}
+	}
+
+	var legacy int64
+	if err := db.Model(&models.SQLDocument{}).Where("account_id = 0 OR account_id IS NULL").Count(&legacy).Error; err != nil {
+		return fmt.Errorf("failed to count the transactions without an account: %w", err)
+	}
+	if legacy == 0 {
+		return nil
+	}
+
+	email := strings.ToLower(strings.TrimSpace(os.Getenv("SMTP_EMAIL_TO")))
+	if email == "" {
+		return errors.New("SMTP_EMAIL_TO must name the owner of the transactions stored without an account")
+	}
+	acc := models.Account{Email: email, Currency: strings.ToUpper(strings.TrimSpace(os.Getenv("DEFAULT_CURRENCY")))}
+	if acc.Currency == "" {
+		acc.Currency = "USD"
+	}
+	if err := db.Where("email = ?", email).FirstOrCreate(&acc).Error; err != nil {
+		return fmt.Errorf("failed to create the legacy account %s: %w", email, err)
+	}
+
+	err := db.Model(&models.SQLDocument{}).
+		Where("account_id = 0 OR account_id IS NULL").
+		Update("account_id", acc.Id).Error
+	if err != nil {
+		return fmt.Errorf("failed to assign the legacy transactions to account %d: %w", acc.Id, err)
+	}
+	return nil
+}
+
+// migrateAmounts converts the float amounts of the legacy transaction column into the exact cents
+// of amount_cents, then makes amount_cents NOT NULL DEFAULT 0. It does nothing once the ledger has
+// been converted.
+func migrateAmounts(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&models.SQLDocument{}) {
+		return nil // New database, created by AutoMigrate
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&models.SQLDocument{}); err != nil {
+		return fmt.Errorf("failed to parse the ledger model: %w", err)
+	}
+	table := clause.Table{Name: stmt.Schema.Table}
+
+	if !m.HasColumn(&models.SQLDocument{}, "amount_cents") {
+		if err := db.Exec("ALTER TABLE ? ADD amount_cents BIGINT NULL", table).Error; err != nil {
+			return fmt.Errorf("failed to add the amount_cents column: %w", err)
+		}
+	}
+
+	// Copy the legacy amounts, then drop their column so the copy only happens once
+	if m.HasColumn(&models.SQLDocument{}, "transaction") {
+		if err := db.Exec("UPDATE ? SET amount_cents = ROUND(`transaction` * 100) WHERE amount_cents IS NULL", table).Error; err != nil {
+			return fmt.Errorf("failed to convert the legacy amounts: %w", err)
+		}
+		if err := m.DropColumn(&models.SQLDocument{}, "transaction"); err != nil {
+			return fmt.Errorf("failed to drop the legacy transaction column: %w", err)
+		}
+	}
+
+	columns, err := m.ColumnTypes(&models.SQLDocument{})
+	if err != nil {
+		return fmt.Errorf("failed to read the columns of %s: %w", table.Name, err)
+	}
+	for _, column := range columns {
+		if nullable, ok := column.Nullable(); column.Name() == "amount_cents" && ok && !nullable {
+			return nil // Already converted
+		}
+	}
+
+	if err := db.Exec("UPDATE ? SET amount_cents = 0 WHERE amount_cents IS NULL", table).Error; err != nil {
+		return fmt.Errorf("failed to fill the missing amounts: %w", err)
+	}
+	if err := db.Exec("ALTER TABLE ? MODIFY amount_cents BIGINT NOT NULL DEFAULT 0", table).Error; err != nil {
+		return fmt.Errorf("failed to make amount_cents NOT NULL: %w", err)
+	}
+	return nil
+}
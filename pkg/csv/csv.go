@@ -1,150 +1,323 @@
 package csv
 
 import (
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/anomaly"
+	"stori_challenge/pkg/category"
+	"stori_challenge/pkg/exchange"
+	"stori_challenge/pkg/imports"
 	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+)
 
-	"gorm.io/gorm"
+// Import policies decide what happens to the rest of the file when a row can't be imported.
+const (
+	PolicyStrict  = "strict"  // Roll back the whole import on the first bad row
+	PolicyLenient = "lenient" // Skip bad rows and import the rest
 )
 
-// ProcessCSVFile processes the given CSV file and stores the data in the database.
-func ProcessCSVFile(filePath string) error {
+// maxDescriptionLength is the size of the description column.
+const maxDescriptionLength = 255
+
+// ImportOptions describes where and on whose behalf a CSV file is imported.
+type ImportOptions struct {
+	AccountId  uint   // Account that owns the imported rows
+	FileName   string // Original name of the uploaded file
+	Uploader   string // Email address of the uploader
+	Policy     string // PolicyStrict or PolicyLenient, see ParsePolicy
+	Year       int    // Year of M/D dates, 0 to infer it from the months the file spans, see ParseYear
+	Currency   string // Currency of rows without a Currency column, usually the account currency
+	Profile    string // Name of the import Profile describing the file, empty for DefaultProfile
+	OnConflict string // ConflictSkip, ConflictOverwrite or ConflictFail, see ParseConflictPolicy
+
+	// Progress, when set, is called with the number of rows read so far after every batch of rows and at the end
+	Progress func(rows int) `json:"-"`
+}
+
+// rowConverter turns the raw rows of a CSV file into SQLDocuments.
+type rowConverter struct {
+	profile  Profile               // Dialect of the file
+	columns  columnMap             // Position of each field, read from the header
+	dates    dateParser            // Resolves the values of the Date column
+	currency string                // Currency of rows without a Currency value
+	rates    exchange.RateProvider // Rates into currency; rows in currencies without one are rejected
+	category *category.Categorizer // Assigns the category of each row, nil to leave rows uncategorized
+	anomaly  *anomaly.Detector     // Flags the rows deviating from the account's history, nil to flag none
+}
+
+// ParsePolicy validates an import policy, falling back to IMPORT_POLICY or lenient when empty.
+func ParsePolicy(policy string) (string, error) {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	if policy == "" {
+		policy = strings.ToLower(os.Getenv("IMPORT_POLICY"))
+	}
+	if policy == "" {
+		policy = PolicyLenient
+	}
+
+	if policy != PolicyStrict && policy != PolicyLenient {
+		return "", fmt.Errorf("política de importación inválida: %s", policy)
+	}
+	return policy, nil
+}
+
+// ProcessCSVFile processes the given CSV file and stores the data in the database under the given account.
+// Every file with a valid header is recorded as an ImportBatch. The returned ImportReport lists the rows
+// that were accepted or rejected and is filled in even when the import fails.
+func ProcessCSVFile(filePath string, opts ImportOptions) (ImportReport, error) {
+	policy, err := ParsePolicy(opts.Policy)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	onConflict, err := ParseConflictPolicy(opts.OnConflict)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	profile, err := LookupProfile(opts.Profile)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("error al abrir el archivo: %v", err)
+		return ImportReport{}, fmt.Errorf("error al abrir el archivo: %v", err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	reader.TrimLeadingSpace = true
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		return ImportReport{}, err
+	}
 
-	if err := validateCSVHeader(reader); err != nil {
-		return err
+	// Reject files that are not transaction exports before recording a batch
+	reader := newCSVReader(file, profile)
+	columns, err := validateCSVHeader(reader, profile)
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("%w: %v", ErrInvalidFile, err)
 	}
 
-	rows, err := reader.ReadAll()
+	// Without a year, the years of M/D dates are inferred from the months the whole file spans
+	var months [13]bool
+	if opts.Year == 0 {
+		if months, err = scanMonths(file, reader, columns, profile); err != nil {
+			return ImportReport{}, err
+		}
+		reader = newCSVReader(file, profile)
+		if _, err := reader.Read(); err != nil {
+			return ImportReport{}, fmt.Errorf("error al leer la cabecera: %v", err)
+		}
+	}
+
+	currency, err := money.ParseCurrency(opts.Currency)
 	if err != nil {
-		return fmt.Errorf("error al leer las filas: %v", err)
+		return ImportReport{}, err
 	}
 
-	return processCSVRows(rows)
-}
+	// Rows in other currencies need a rate into the account currency to be summarized
+	rates, err := exchange.DefaultProvider()
+	if err != nil {
+		return ImportReport{}, err
+	}
 
-// validateCSVHeader validates the header of the CSV file.
-func validateCSVHeader(reader *csv.Reader) error {
-	headers, err := reader.Read()
+	// Categorize the rows with the account's current rules
+	categorizer, err := category.ForAccount(opts.AccountId)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	// Compare the rows with the account's ledger before the import
+	detector, err := anomaly.ForAccount(opts.AccountId)
 	if err != nil {
-		return fmt.Errorf("error al leer la cabecera: %v", err)
+		return ImportReport{}, err
 	}
 
-	expectedHeaders := []string{"Id", "Date", "Transaction"}
-	for i, header := range expectedHeaders {
-		if strings.TrimSpace(headers[i]) != header {
-			return fmt.Errorf("cabecera inválida: se esperaba %s, pero se encontró %s", header, headers[i])
+	batch := models.ImportBatch{
+		AccountId: opts.AccountId,
+		FileName:  opts.FileName,
+		Checksum:  checksum,
+		Uploader:  opts.Uploader,
+		Profile:   profile.Name,
+	}
+	if err := imports.StartBatch(&batch); err != nil {
+		return ImportReport{}, err
+	}
+
+	report := ImportReport{ImportId: batch.Id, ConflictPolicy: onConflict, Errors: []RowError{}}
+	conv := rowConverter{
+		profile:  profile,
+		columns:  columns,
+		dates:    newDateParser(opts.Year, batch.StartedAt, profile.DayFirst),
+		currency: currency,
+		rates:    rates,
+		category: categorizer,
+		anomaly:  detector,
+	}
+	conv.dates.inferYears(months)
+	if err := importRows(reader, &batch, policy, onConflict, conv, &report, opts.Progress); err != nil {
+		if finishErr := imports.FinishBatch(&batch, models.ImportStatusFailed); finishErr != nil {
+			log.Println("Error:", finishErr)
 		}
+		return report, err
 	}
-	return nil
+
+	return report, imports.FinishBatch(&batch, models.ImportStatusCompleted)
 }
 
-// processCSVRows processes each row in the CSV and stores them in the database.
-func processCSVRows(rows [][]string) error {
-	for idx, row := range rows {
-		if err := validateCSVRow(row, idx); err != nil {
-			return err
-		}
+// fileChecksum returns the hex encoded SHA-256 of the file and rewinds it for reading.
+func fileChecksum(file *os.File) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("error al calcular el checksum: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("error al rebobinar el archivo: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-		csvRow := models.CSVDocument{
-			Id:          row[0],
-			Date:        row[1],
-			Transaction: row[2],
-		}
+// newCSVReader returns a reader of the rows of file in the dialect of the profile.
+func newCSVReader(file io.Reader, profile Profile) *csv.Reader {
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1 // Column counts are checked per row by validateCSVRow
+	profile.configure(reader)
+	return reader
+}
 
-		sqlDoc, err := dataCSVToSQL(csvRow)
-		if err != nil {
-			log.Println("Error converting CSV to SQL:", err)
+// scanMonths reads the Date column of the rows left in reader and returns the months of its M/D
+// dates, then rewinds the file. Rows are read one at a time, and rows that can't be read or
+// parsed are left for the import to reject.
+func scanMonths(file *os.File, reader *csv.Reader, columns columnMap, profile Profile) ([13]bool, error) {
+	var months [13]bool
+	dates := newDateParser(0, time.Time{}, profile.DayFirst)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
 			continue
 		}
+		if err != nil {
+			return months, fmt.Errorf("error al leer las filas: %v", err)
+		}
 
-		if err := addTransactionToDB(sqlDoc); err != nil {
-			log.Println("Error adding transaction to DB:", err)
+		if len(row) != columns.width {
+			continue
+		}
+		if month, _, err := dates.monthDay(columns.value(row, FieldDate)); err == nil {
+			months[month] = true
 		}
 	}
 
-	return nil
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return months, fmt.Errorf("error al rebobinar el archivo: %v", err)
+	}
+	return months, nil
 }
 
-// validateCSVRow validates the individual row of the CSV.
-func validateCSVRow(row []string, rowIndex int) error {
-	if len(row) != 3 {
-		return fmt.Errorf("la fila %d no tiene exactamente 3 columnas: %v", rowIndex+2, row)
+// validateCSVHeader validates the header of the CSV file against the profile and returns the
+// position of each field.
+func validateCSVHeader(reader *csv.Reader, profile Profile) (columnMap, error) {
+	headers, err := reader.Read()
+	if err != nil {
+		return columnMap{}, fmt.Errorf("error al leer la cabecera: %v", err)
 	}
-	return nil
+	return profile.mapHeader(headers)
 }
 
-// addTransactionToDB adds a SQLDocument to the database if it doesn't already exist.
-func addTransactionToDB(sqlDoc models.SQLDocument) error {
-	if err := transactionExists(sqlDoc.IdTransaction); err != nil {
-		log.Println("Error:", err)
-		return err
+// rowToSQL validates a raw CSV row and converts it into a SQLDocument.
+func (c rowConverter) rowToSQL(row []string) (models.SQLDocument, error) {
+	if err := validateCSVRow(row, c.columns.width); err != nil {
+		return models.SQLDocument{}, err
 	}
 
-	if err := config.GetDB().Create(&sqlDoc).Error; err != nil {
-		return fmt.Errorf("error al crear la transacción: %v", err)
+	csvRow := models.CSVDocument{
+		Id:          c.columns.value(row, FieldId),
+		Date:        c.columns.value(row, FieldDate),
+		Transaction: c.profile.normalizeAmount(c.columns.value(row, FieldTransaction)),
+		Currency:    c.columns.value(row, FieldCurrency),
+		Description: c.columns.value(row, FieldDescription),
 	}
-	log.Println("Transacción creada exitosamente.")
-	return nil
+	return dataCSVToSQL(csvRow, c)
 }
 
-// transactionExists checks if a transaction already exists in the database.
-func transactionExists(idTransaction uint) error {
-	var existingTransaction models.SQLDocument
-
-	if err := config.GetDB().Where("id_transaction = ?", idTransaction).First(&existingTransaction).Error; err != nil {
-		if gorm.ErrRecordNotFound == err {
-			return nil // No existe, retorna nil
-		}
-		return err
+// validateCSVRow validates that the individual row of the CSV has as many columns as the header.
+func validateCSVRow(row []string, columns int) error {
+	if len(row) != columns {
+		return newRowError("", "la fila no tiene exactamente %d columnas: %v", columns, row)
 	}
-
-	return fmt.Errorf("la transacción con IdTransaction %d ya existe", idTransaction)
+	return nil
 }
 
-// dataCSVToSQL converts a CSVDocument to a SQLDocument.
-func dataCSVToSQL(csvRow models.CSVDocument) (models.SQLDocument, error) {
-	dateParts := strings.Split(csvRow.Date, "/")
-	if len(dateParts) != 2 {
-		return models.SQLDocument{}, fmt.Errorf("invalid date format for row: %v", csvRow)
+// dataCSVToSQL converts a CSVDocument to a SQLDocument, resolving its date and currency with conv.
+func dataCSVToSQL(csvRow models.CSVDocument, conv rowConverter) (models.SQLDocument, error) {
+	date, err := conv.dates.parse(csvRow.Date)
+	if err != nil {
+		return models.SQLDocument{}, newRowError(FieldDate, "%v", err)
 	}
 
-	month, day := dateParts[0], dateParts[1]
-
 	IdValue, err := stringToUint(csvRow.Id)
 	if err != nil {
-		return models.SQLDocument{}, fmt.Errorf("error converting Id: %v", err)
+		return models.SQLDocument{}, newRowError(FieldId, "%v", err)
 	}
 
-	TransactionFloat64, err := stringToFloat64(csvRow.Transaction)
+	amount, err := money.Parse(csvRow.Transaction)
 	if err != nil {
-		return models.SQLDocument{}, fmt.Errorf("error converting Transaction: %v", err)
+		return models.SQLDocument{}, newRowError(FieldTransaction, "%v", err)
+	}
+
+	currency := conv.currency
+	if strings.TrimSpace(csvRow.Currency) != "" {
+		if currency, err = money.ParseCurrency(csvRow.Currency); err != nil {
+			return models.SQLDocument{}, newRowError(FieldCurrency, "%v", err)
+		}
+		if _, err := conv.rates.Rate(currency, conv.currency); err != nil {
+			return models.SQLDocument{}, newRowError(FieldCurrency, "no hay tipo de cambio de %s a %s", currency, conv.currency)
+		}
+	}
+
+	description, err := normalizeDescription(csvRow.Description)
+	if err != nil {
+		return models.SQLDocument{}, newRowError(FieldDescription, "%v", err)
 	}
 
-	year := getCurrentYear()
 	sqlDoc := models.SQLDocument{
 		IdTransaction: IdValue,
-		Date:          fmt.Sprintf("%v-%v-%v", year, month, day),
-		Transaction:   TransactionFloat64,
+		Date:          date.Format("2006-01-02"),
+		Transaction:   amount,
+		Currency:      currency,
+		Description:   description,
+		Category:      conv.category.Categorize(description, amount),
+		Anomalies:     conv.anomaly.Flag(date, amount, currency),
 	}
 
 	return sqlDoc, nil
 }
 
+// normalizeDescription trims a description and checks that it fits in the description column.
+func normalizeDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return "", fmt.Errorf("la descripción excede %d caracteres", maxDescriptionLength)
+	}
+	return description, nil
+}
+
 // stringToUint converts a string to uint.
 func stringToUint(s string) (uint, error) {
 	num, err := strconv.ParseUint(s, 10, 0)
@@ -154,20 +327,6 @@ func stringToUint(s string) (uint, error) {
 	return uint(num), nil
 }
 
-// stringToFloat64 converts a string to float64.
-func stringToFloat64(s string) (float64, error) {
-	floatValue, err := strconv.ParseFloat(s, 64)
-	if err != nil {
-		return 0, fmt.Errorf("error converting string to float64: %v", err)
-	}
-	return floatValue, nil
-}
-
-// getCurrentYear returns the current year.
-func getCurrentYear() int {
-	return time.Now().Year()
-}
-
 // CheckFileSize verifies if the file size is less than the specified limit in megabytes.
 func CheckFileSize(filePath string) error {
 	fileInfo, err := os.Stat(filePath)
@@ -176,7 +335,7 @@ func CheckFileSize(filePath string) error {
 	}
 
 	fileSize := fileInfo.Size()
-	limitBytes, err := getFileSizeLimit()
+	limitBytes, err := FileSizeLimit()
 	if err != nil {
 		return err
 	}
@@ -187,11 +346,11 @@ func CheckFileSize(filePath string) error {
 	return nil
 }
 
-// getFileSizeLimit retrieves the file size limit from environment variable.
-func getFileSizeLimit() (int64, error) {
+// FileSizeLimit retrieves the file size limit in bytes from the FILE_SIZE_LIMIT environment variable (in megabytes).
+func FileSizeLimit() (int64, error) {
 	limitStr := os.Getenv("FILE_SIZE_LIMIT")
 	if limitStr == "" {
-		limitStr = "1" // 1 MB por defecto
+		limitStr = "512" // 512 MB por defecto, las filas se procesan en streaming
 	}
 
 	limitMB, err := strconv.ParseFloat(limitStr, 64)
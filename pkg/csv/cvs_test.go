@@ -1,8 +1,18 @@
 package csv
 
 import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math/big"
+	"os"
 	"path/filepath"
+	"stori_challenge/pkg/category"
+	"stori_challenge/pkg/exchange"
+	"stori_challenge/pkg/models"
+	"strings"
 	"testing"
+	"time"
 )
 
 // testPair defines a structure for holding test case information,
@@ -28,7 +38,7 @@ func TestProcessCSVFile(t *testing.T) {
 		csvFile := filepath.Join(".", pair.filePath)
 
 		// Call the ProcessCSVFile function
-		err := ProcessCSVFile(csvFile)
+		_, err := ProcessCSVFile(csvFile, ImportOptions{AccountId: 1, FileName: pair.filePath})
 
 		// Check if the error result matches the expected outcome
 		if (err != nil) != pair.expectedError {
@@ -37,3 +47,481 @@ func TestProcessCSVFile(t *testing.T) {
 		}
 	}
 }
+
+// policyPair defines a structure for holding import policy test cases.
+type policyPair struct {
+	input    string // Policy as received in the request
+	expected string // Normalized policy
+	hasErr   bool   // Indicates if an error is expected for this test case
+}
+
+// List of policy test cases with corresponding expected outcomes
+var policyTests = []policyPair{
+	{"strict", PolicyStrict, false},
+	{" Lenient ", PolicyLenient, false},
+	{"", PolicyLenient, false}, // Falls back to the default policy
+	{"best-effort", "", true},
+}
+
+// TestParsePolicy tests the ParsePolicy function with various inputs
+func TestParsePolicy(t *testing.T) {
+	t.Setenv("IMPORT_POLICY", "")
+
+	for _, pair := range policyTests {
+		policy, err := ParsePolicy(pair.input)
+
+		// Check if the result matches the expected outcome
+		if (err != nil) != pair.hasErr || policy != pair.expected {
+			t.Errorf("For %q expected (%q, error: %v), got (%q, %v)", pair.input, pair.expected, pair.hasErr, policy, err)
+		}
+	}
+}
+
+// rowPair defines a structure for holding row conversion test cases.
+type rowPair struct {
+	row    []string // Raw CSV row
+	column string   // Column expected in the RowError, empty for whole-row errors
+	hasErr bool     // Indicates if an error is expected for this test case
+}
+
+// testRates are the exchange rates known to the row tests, which have USD as account currency
+var testRates = exchange.Table{
+	{From: "USD", To: "MXN"}: big.NewRat(17, 1),
+	{From: "EUR", To: "USD"}: big.NewRat(11, 10),
+}
+
+// List of row test cases with corresponding expected outcomes
+var rowTests = []rowPair{
+	{[]string{"0", "7/15", "+60.5", "MXN"}, "", false},
+	{[]string{"0", "7/15", "+60.5", ""}, "", false}, // Falls back to the account currency
+	{[]string{"0", "7/15", "+60.5"}, "", true},
+	{[]string{"x", "7/15", "+60.5", "USD"}, "Id", true},
+	{[]string{"0", "2024-07-15-01", "+60.5", "USD"}, "Date", true},
+	{[]string{"0", "7/15", "abc", "USD"}, "Transaction", true},
+	{[]string{"0", "7/15", "+60.5", "PESOS"}, "Currency", true},
+	{[]string{"0", "7/15", "+60.5", "JPY"}, "Currency", true}, // No rate into the account currency
+}
+
+// TestRowToSQL tests that invalid rows are reported with the offending column
+func TestRowToSQL(t *testing.T) {
+	columns, err := DefaultProfile.mapHeader([]string{"Id", "Date", "Transaction", "Currency"})
+	if err != nil {
+		t.Fatalf("unexpected header error: %v", err)
+	}
+	conv := rowConverter{
+		profile:  DefaultProfile,
+		columns:  columns,
+		dates:    newDateParser(2024, time.Date(2024, 8, 20, 0, 0, 0, 0, time.UTC), false),
+		currency: "USD",
+		rates:    testRates,
+	}
+
+	for _, pair := range rowTests {
+		_, err := conv.rowToSQL(pair.row)
+
+		// Check if the error result matches the expected outcome
+		if (err != nil) != pair.hasErr {
+			t.Errorf("For %v expected error: %v, got: %v", pair.row, pair.hasErr, err)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+
+		rowErr := asRowError(err, 3)
+		if rowErr.Column != pair.column || rowErr.Line != 3 {
+			t.Errorf("For %v expected column %q at line 3, got %+v", pair.row, pair.column, rowErr)
+		}
+	}
+}
+
+// TestRowDescription tests that descriptions are stored and categorized with the account's rules
+func TestRowDescription(t *testing.T) {
+	columns, err := DefaultProfile.mapHeader([]string{"Id", "Date", "Transaction", "Merchant"})
+	if err != nil {
+		t.Fatalf("unexpected header error: %v", err)
+	}
+	categorizer, err := category.New([]models.CategoryRule{
+		{Id: 1, Category: "Groceries", Kind: category.KindKeyword, Pattern: "market"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected rules error: %v", err)
+	}
+	conv := rowConverter{
+		profile:  DefaultProfile,
+		columns:  columns,
+		dates:    newDateParser(2024, time.Date(2024, 8, 20, 0, 0, 0, 0, time.UTC), false),
+		currency: "USD",
+		category: categorizer,
+	}
+
+	sqlDoc, err := conv.rowToSQL([]string{"1", "7/15", "-10.3", " Super Market "})
+	if err != nil {
+		t.Fatalf("unexpected row error: %v", err)
+	}
+	if sqlDoc.Description != "Super Market" || sqlDoc.Category != "Groceries" {
+		t.Errorf("unexpected document %+v", sqlDoc)
+	}
+
+	sqlDoc, err = conv.rowToSQL([]string{"2", "7/16", "-10.3", ""})
+	if err != nil || sqlDoc.Description != "" || sqlDoc.Category != "" {
+		t.Errorf("expected an uncategorized row, got %+v (%v)", sqlDoc, err)
+	}
+
+	// Descriptions longer than the column are rejected
+	_, err = conv.rowToSQL([]string{"3", "7/17", "-10.3", strings.Repeat("x", maxDescriptionLength+1)})
+	if rowErr := asRowError(err, 4); err == nil || rowErr.Column != FieldDescription {
+		t.Errorf("expected a Description error, got %v", err)
+	}
+}
+
+// datePair defines a structure for holding date parsing test cases.
+type datePair struct {
+	value    string // Value of the Date column
+	year     int    // Year supplied with the upload, 0 to infer it
+	expected string // Expected date in YYYY-MM-DD format
+	hasErr   bool   // Indicates if an error is expected for this test case
+}
+
+// List of date test cases for an upload made on 2025-01-10
+var dateTests = []datePair{
+	{"2024-07-15", 0, "2024-07-15", false},
+	{"7/15/2023", 0, "2023-07-15", false},
+	{"2024-07-15T10:30:00-06:00", 0, "2024-07-15", false},
+	{"12/30", 0, "2024-12-30", false}, // December statement uploaded in January
+	{"1/5", 0, "2025-01-05", false},
+	{"7/15", 2022, "2022-07-15", false},
+	{"2/29", 2024, "2024-02-29", false},
+	{"2/29", 2023, "", true},
+	{"2/30", 0, "", true},
+	{"13/1", 0, "", true},
+	{"2024-02-30", 0, "", true},
+	{"15/7", 0, "", true},
+	{"yesterday", 0, "", true},
+}
+
+// TestDateParser tests the parsing of the supported date formats and year inference
+func TestDateParser(t *testing.T) {
+	uploadedAt := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, pair := range dateTests {
+		date, err := newDateParser(pair.year, uploadedAt, false).parse(pair.value)
+
+		// Check if the error result matches the expected outcome
+		if (err != nil) != pair.hasErr {
+			t.Errorf("For %q expected error: %v, got: %v", pair.value, pair.hasErr, err)
+			continue
+		}
+		if err == nil && date.Format("2006-01-02") != pair.expected {
+			t.Errorf("For %q expected %s, got %s", pair.value, pair.expected, date.Format("2006-01-02"))
+		}
+	}
+}
+
+// spanYearsTests lists the years inferred for the months of M/D dates uploaded on 2025-01-10.
+var spanYearsTests = []struct {
+	months   []time.Month       // Months of the file's M/D dates
+	expected map[time.Month]int // Expected year of each month
+}{
+	{nil, map[time.Month]int{}},
+	{[]time.Month{time.December, time.January}, map[time.Month]int{time.December: 2024, time.January: 2025}}, // Rollover
+	{[]time.Month{time.November, time.December}, map[time.Month]int{time.November: 2024, time.December: 2024}},
+	{[]time.Month{time.January}, map[time.Month]int{time.January: 2025}},
+	{[]time.Month{time.March, time.September}, map[time.Month]int{time.March: 2024, time.September: 2024}},
+	{[]time.Month{time.October, time.November, time.December, time.January}, map[time.Month]int{
+		time.October: 2024, time.November: 2024, time.December: 2024, time.January: 2025,
+	}},
+}
+
+// TestSpanYears tests that the years of M/D dates follow the span of the file's months
+func TestSpanYears(t *testing.T) {
+	uploadedAt := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, test := range spanYearsTests {
+		var months [13]bool
+		for _, m := range test.months {
+			months[m] = true
+		}
+
+		years := spanYears(months, uploadedAt)
+		for m := time.January; m <= time.December; m++ {
+			if years[m] != test.expected[m] {
+				t.Errorf("For months %v expected %s in %d, got %d", test.months, m, test.expected[m], years[m])
+			}
+		}
+	}
+
+	// A whole year of months ends in the month of the upload
+	var all [13]bool
+	for m := time.January; m <= time.December; m++ {
+		all[m] = true
+	}
+	years := spanYears(all, uploadedAt)
+	if years[time.January] != 2025 || years[time.February] != 2024 || years[time.December] != 2024 {
+		t.Errorf("For every month expected February 2024 to January 2025, got %v", years)
+	}
+}
+
+// TestScanMonths tests that the months of a file's M/D dates are collected and the file rewound
+func TestScanMonths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statement.csv")
+	content := "Id,Date,Transaction\n1,12/30,-10.5\n2,1/5,+20\n3,2024-07-15,+1\n4,2/3\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader := newCSVReader(file, DefaultProfile)
+	columns, err := validateCSVHeader(reader, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	months, err := scanMonths(file, reader, columns, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Full dates and rows with missing columns don't count
+	expected := [13]bool{time.January: true, time.December: true}
+	if months != expected {
+		t.Errorf("expected months %v, got %v", expected, months)
+	}
+	if offset, _ := file.Seek(0, io.SeekCurrent); offset != 0 {
+		t.Errorf("expected the file to be rewound, got offset %d", offset)
+	}
+}
+
+// TestProfile tests reading a semicolon separated export with its own header names,
+// column order and decimal commas
+func TestProfile(t *testing.T) {
+	profile := Profile{
+		Name:               "bank",
+		Delimiter:          ";",
+		DecimalComma:       true,
+		ThousandsSeparator: ".",
+		DayFirst:           true,
+		Columns: map[string][]string{
+			FieldId:          {"Referencia"},
+			FieldDate:        {"Fecha"},
+			FieldTransaction: {"Importe"},
+			FieldCurrency:    {"Divisa"},
+		},
+	}
+	if err := profile.validate(); err != nil {
+		t.Fatalf("unexpected profile error: %v", err)
+	}
+
+	content := "Fecha;Concepto;Importe;Referencia;Divisa\n15/07/2024;\"Pago; tienda\";-1.234,56;7;EUR\n"
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.FieldsPerRecord = -1
+	profile.configure(reader)
+
+	columns, err := validateCSVHeader(reader, profile)
+	if err != nil {
+		t.Fatalf("unexpected header error: %v", err)
+	}
+	row, err := reader.Read()
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+
+	conv := rowConverter{profile: profile, columns: columns, dates: newDateParser(0, time.Now(), true), currency: "USD", rates: testRates}
+	sqlDoc, err := conv.rowToSQL(row)
+	if err != nil {
+		t.Fatalf("unexpected row error: %v", err)
+	}
+	if sqlDoc.IdTransaction != 7 || sqlDoc.Date != "2024-07-15" || sqlDoc.Transaction != -123456 || sqlDoc.Currency != "EUR" {
+		t.Errorf("unexpected document %+v", sqlDoc)
+	}
+
+	// The default profile doesn't know these headers
+	if _, err := DefaultProfile.mapHeader([]string{"Fecha", "Importe", "Referencia"}); err == nil {
+		t.Errorf("expected a missing column error for the default profile")
+	}
+}
+
+// profileValidateTests lists profiles and whether validate accepts them.
+var profileValidateTests = []struct {
+	name    string  // Case description
+	profile Profile // Profile to validate
+	valid   bool    // Whether the profile is valid
+}{
+	{"default", DefaultProfile, true},
+	{"semicolon with decimal comma", Profile{Name: "eu", Delimiter: ";", DecimalComma: true, ThousandsSeparator: ".", Columns: DefaultProfile.Columns}, true},
+	{"tab", Profile{Name: "tab", Delimiter: "\t", ThousandsSeparator: ",", Columns: DefaultProfile.Columns}, true},
+	{"no name", Profile{Delimiter: ",", Columns: DefaultProfile.Columns}, false},
+	{"empty delimiter", Profile{Name: "p", Columns: DefaultProfile.Columns}, false},
+	{"multi-rune delimiter", Profile{Name: "p", Delimiter: ";;", Columns: DefaultProfile.Columns}, false},
+	{"quote delimiter", Profile{Name: "p", Delimiter: `"`, Columns: DefaultProfile.Columns}, false},
+	{"carriage return delimiter", Profile{Name: "p", Delimiter: "\r", Columns: DefaultProfile.Columns}, false},
+	{"newline delimiter", Profile{Name: "p", Delimiter: "\n", Columns: DefaultProfile.Columns}, false},
+	{"invalid UTF-8 delimiter", Profile{Name: "p", Delimiter: "\xff", Columns: DefaultProfile.Columns}, false},
+	{"decimal comma and comma delimiter", Profile{Name: "p", Delimiter: ",", DecimalComma: true, Columns: DefaultProfile.Columns}, false},
+	{"thousands equal to decimal", Profile{Name: "p", Delimiter: ";", ThousandsSeparator: ".", Columns: DefaultProfile.Columns}, false},
+	{"thousands equal to delimiter", Profile{Name: "p", Delimiter: ";", ThousandsSeparator: ";", Columns: DefaultProfile.Columns}, false},
+	{"unknown column", Profile{Name: "p", Delimiter: ",", Columns: map[string][]string{
+		FieldId: {"Id"}, FieldDate: {"Date"}, FieldTransaction: {"Amount"}, "Balance": {"Balance"},
+	}}, false},
+	{"missing column", Profile{Name: "p", Delimiter: ",", Columns: map[string][]string{FieldId: {"Id"}, FieldDate: {"Date"}}}, false},
+}
+
+// TestProfileValidate tests the profiles accepted and refused when the profiles file is loaded
+func TestProfileValidate(t *testing.T) {
+	for _, test := range profileValidateTests {
+		if err := test.profile.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+// TestLookupProfile tests that unknown profiles are rejected
+func TestLookupProfile(t *testing.T) {
+	if profile, err := LookupProfile(""); err != nil || profile.Name != DefaultProfile.Name {
+		t.Errorf("expected the default profile, got %q (%v)", profile.Name, err)
+	}
+	if _, err := LookupProfile("does-not-exist"); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, got %v", err)
+	}
+}
+
+// TestLookupProfileLoadError tests that a profiles file that can't be loaded is reported as such
+// and loaded again once fixed
+func TestLookupProfileLoadError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	t.Setenv("IMPORT_PROFILES_FILE", path)
+	profiles = nil
+	t.Cleanup(func() { profiles = nil })
+
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LookupProfile("bank"); err == nil || errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("expected a load error, got %v", err)
+	}
+	if err := LoadProfiles(); err == nil {
+		t.Errorf("expected LoadProfiles to fail")
+	}
+
+	content := `[{"name": "bank", "delimiter": ";", "columns": {"Id": ["Referencia"], "Date": ["Fecha"], "Transaction": ["Importe"]}}]`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if profile, err := LookupProfile("bank"); err != nil || profile.Name != "bank" {
+		t.Errorf("expected the bank profile once the file is fixed, got %q (%v)", profile.Name, err)
+	}
+}
+
+// TestRejectCapsReportedErrors tests that lenient imports keep counting rejected rows
+// without growing the report past maxReportedErrors
+func TestRejectCapsReportedErrors(t *testing.T) {
+	report := &ImportReport{}
+	imp := &rowImporter{policy: PolicyLenient, report: report}
+
+	for line := 2; line < maxReportedErrors+12; line++ {
+		if err := imp.reject(&RowError{Line: line, Reason: "invalid"}); err != nil {
+			t.Fatalf("lenient reject returned an error: %v", err)
+		}
+	}
+	if report.Rejected != maxReportedErrors+10 || len(report.Errors) != maxReportedErrors || !report.ErrorsTruncated {
+		t.Errorf("unexpected report: rejected=%d errors=%d truncated=%v", report.Rejected, len(report.Errors), report.ErrorsTruncated)
+	}
+
+	// Strict imports stop on the first rejected row
+	strict := &rowImporter{policy: PolicyStrict, report: &ImportReport{}}
+	if err := strict.reject(&RowError{Line: 2, Reason: "invalid"}); err == nil {
+		t.Errorf("expected strict reject to return the row error")
+	}
+}
+
+// TestConflictsWithinBatch tests how each conflict policy handles a transaction repeated in the same batch
+func TestConflictsWithinBatch(t *testing.T) {
+	columns, _ := DefaultProfile.mapHeader([]string{"Id", "Date", "Transaction"})
+	conv := rowConverter{profile: DefaultProfile, columns: columns, dates: newDateParser(2024, time.Now(), false), currency: "USD", rates: testRates}
+
+	for _, onConflict := range []string{ConflictSkip, ConflictOverwrite, ConflictFail} {
+		report := &ImportReport{}
+		imp := &rowImporter{
+			batch:      &models.ImportBatch{Id: 1, AccountId: 1},
+			policy:     PolicyLenient,
+			conv:       conv,
+			report:     report,
+			batchSize:  100, // Large enough to never flush to the database
+			pendingId:  map[uint]int{},
+			onConflict: onConflict,
+		}
+
+		if err := imp.add(2, []string{"1", "7/15", "+60.5"}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", onConflict, err)
+		}
+		err := imp.add(3, []string{"1", "7/16", "-10.3"})
+
+		switch onConflict {
+		case ConflictSkip:
+			if err != nil || report.SkippedDuplicates != 1 || imp.pending[0].doc.Transaction != 6050 {
+				t.Errorf("skip: unexpected result %+v (%v)", report, err)
+			}
+		case ConflictOverwrite:
+			if err != nil || report.Overwritten != 0 || report.Superseded != 1 || imp.pending[0].doc.Transaction != -1030 {
+				t.Errorf("overwrite: unexpected result %+v (%v)", report, err)
+			}
+		case ConflictFail:
+			var rowErr *RowError
+			if !errors.As(err, &rowErr) || rowErr.Line != 3 {
+				t.Errorf("fail: expected a conflict on line 3, got %v", err)
+			}
+		}
+	}
+}
+
+// TestRepeatedIdInFile tests that an ID repeated within a file under ConflictOverwrite keeps only its
+// last line, without counting the replaced lines as imported
+func TestRepeatedIdInFile(t *testing.T) {
+	columns, _ := DefaultProfile.mapHeader([]string{"Id", "Date", "Transaction"})
+	report := &ImportReport{}
+	imp := &rowImporter{
+		batch:      &models.ImportBatch{Id: 1, AccountId: 1},
+		policy:     PolicyLenient,
+		conv:       rowConverter{profile: DefaultProfile, columns: columns, dates: newDateParser(2024, time.Now(), false), currency: "USD", rates: testRates},
+		report:     report,
+		batchSize:  100, // Large enough to never flush to the database
+		pendingId:  map[uint]int{},
+		onConflict: ConflictOverwrite,
+	}
+
+	for line, amount := range []string{"+1", "+2", "+3"} {
+		if err := imp.add(line+2, []string{"1", "7/15", amount}); err != nil {
+			t.Fatalf("line %d: unexpected error: %v", line+2, err)
+		}
+	}
+	if len(imp.pending) != 1 || imp.pending[0].line != 4 || imp.pending[0].doc.Transaction != 300 {
+		t.Errorf("expected only line 4 to be queued, got %+v", imp.pending)
+	}
+	if report.Accepted != 0 || report.Overwritten != 0 || report.Superseded != 2 {
+		t.Errorf("unexpected report before the flush: %+v", report)
+	}
+
+	// Once stored, the queued row counts once; a row replacing one stored by the same import counts as superseded
+	imp.count(false, false, false)
+	imp.count(true, true, false)
+	if report.Accepted != 1 || report.Overwritten != 0 || report.Superseded != 3 {
+		t.Errorf("unexpected report after the flush: %+v", report)
+	}
+}
+
+// TestParseConflictPolicy tests the ParseConflictPolicy function with various inputs
+func TestParseConflictPolicy(t *testing.T) {
+	t.Setenv("IMPORT_CONFLICT_POLICY", "")
+
+	for input, expected := range map[string]string{"": ConflictSkip, "Overwrite": ConflictOverwrite, "fail": ConflictFail} {
+		if policy, err := ParseConflictPolicy(input); err != nil || policy != expected {
+			t.Errorf("For %q expected %q, got %q (%v)", input, expected, policy, err)
+		}
+	}
+	if _, err := ParseConflictPolicy("merge"); err == nil {
+		t.Errorf("expected an error for an unknown conflict policy")
+	}
+}
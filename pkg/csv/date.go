@@ -0,0 +1,156 @@
+package csv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date layouts accepted in the Date column, tried in order. Month/day dates without a year are
+// handled separately by dateParser because their year has to be resolved.
+var fullDateLayouts = []string{
+	time.RFC3339, // 2024-07-15T10:30:00Z
+	"2006-01-02", // 2024-07-15
+	"1/2/2006",   // 7/15/2024
+}
+
+// dateParser turns the values of the Date column into calendar dates.
+type dateParser struct {
+	year     int       // Year used for M/D dates; 0 infers it from the span of the file
+	now      time.Time // Moment of the upload
+	dayFirst bool      // Slash dates are written D/M and D/M/YYYY
+	years    [13]int   // Inferred year of each month of the file's M/D dates, 0 for months without any
+}
+
+// newDateParser returns a dateParser for an upload made at now. A zero year enables year inference.
+func newDateParser(year int, now time.Time, dayFirst bool) dateParser {
+	return dateParser{year: year, now: now, dayFirst: dayFirst}
+}
+
+// inferYears resolves the year of the months in which the file has M/D dates, see spanYears.
+func (p *dateParser) inferYears(months [13]bool) {
+	p.years = spanYears(months, p.now)
+}
+
+// parse converts value into a date, rejecting dates that don't exist such as 2/30.
+func (p dateParser) parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	for _, layout := range fullDateLayouts {
+		if p.dayFirst && layout == "1/2/2006" {
+			layout = "2/1/2006"
+		}
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	month, day, err := p.monthDay(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	year := p.year
+	if year == 0 {
+		year = p.years[month]
+	}
+	if year == 0 {
+		year = inferYear(month, p.now) // Month not seen by inferYears
+	}
+	return validDate(year, month, day)
+}
+
+// monthDay splits a M/D date, or D/M when the file writes the day first.
+func (p dateParser) monthDay(value string) (time.Month, int, error) {
+	parts := strings.Split(strings.TrimSpace(value), "/")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid date format %q", value)
+	}
+	if p.dayFirst {
+		parts[0], parts[1] = parts[1], parts[0]
+	}
+
+	month, errMonth := strconv.Atoi(parts[0])
+	day, errDay := strconv.Atoi(parts[1])
+	if errMonth != nil || errDay != nil || month < 1 || month > 12 {
+		return 0, 0, fmt.Errorf("invalid date format %q", value)
+	}
+	return time.Month(month), day, nil
+}
+
+// spanYears picks the year of each month in which a file has M/D dates. The months are taken as a
+// span of less than a year that starts after the longest run of months without dates, so a
+// statement from December to January rolls over into the next year. The span ends in the latest
+// year that doesn't put its last month after the upload.
+func spanYears(months [13]bool, now time.Time) [13]int {
+	var years [13]int
+
+	// Find the month after the longest run without dates, preferring the spans ending closest
+	// to the upload when several runs are equally long
+	start, longest := time.Month(0), -1
+	for i := 1; i <= 12; i++ {
+		m := (now.Month()+time.Month(i)-1)%12 + 1
+		if !months[m] {
+			continue
+		}
+		gap := 0
+		for prev := (m+10)%12 + 1; !months[prev] && gap < 11; prev = (prev+10)%12 + 1 {
+			gap++
+		}
+		if gap > longest {
+			start, longest = m, gap
+		}
+	}
+	if start == 0 {
+		return years // No M/D dates
+	}
+
+	end := (start+10)%12 + 1
+	for !months[end] {
+		end = (end+10)%12 + 1
+	}
+
+	last := inferYear(end, now)
+	for m := time.January; m <= time.December; m++ {
+		switch {
+		case !months[m]:
+		case start > end && m >= start:
+			years[m] = last - 1 // Before the rollover
+		default:
+			years[m] = last
+		}
+	}
+	return years
+}
+
+// inferYear picks the latest year that doesn't put the month after the upload.
+func inferYear(month time.Month, now time.Time) int {
+	if month > now.Month() {
+		return now.Year() - 1
+	}
+	return now.Year()
+}
+
+// validDate builds a date making sure the day exists in that month of that year.
+func validDate(year int, month time.Month, day int) (time.Time, error) {
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if day < 1 || t.Month() != month || t.Day() != day {
+		return time.Time{}, fmt.Errorf("date %d/%d does not exist in %d", month, day, year)
+	}
+	return t, nil
+}
+
+// ParseYear validates the optional year supplied with an upload; an empty value returns 0.
+func ParseYear(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	year, err := strconv.Atoi(value)
+	if err != nil || year < 1900 || year > 9999 {
+		return 0, fmt.Errorf("año inválido: %s", value)
+	}
+	return year, nil
+}
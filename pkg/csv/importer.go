@@ -0,0 +1,419 @@
+package csv
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"stori_challenge/pkg/anomaly"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Defaults of the streaming importer, overridable with IMPORT_BATCH_SIZE and IMPORT_MAX_ROWS.
+const (
+	defaultBatchSize  = 500     // Rows per multi-row INSERT
+	defaultMaxRows    = 5000000 // Rows accepted in a single file, 0 disables the limit
+	maxReportedErrors = 1000    // Row errors kept in the ImportReport, the rest are only counted
+)
+
+// Conflict policies decide what happens to a row whose IdTransaction is already in the account's ledger.
+const (
+	ConflictSkip      = "skip"      // Keep the stored row and skip the new one
+	ConflictOverwrite = "overwrite" // Replace the stored row with the new one
+	ConflictFail      = "fail"      // Abort the import
+)
+
+// overwrittenColumns are the columns replaced by ConflictOverwrite. The row keeps the import batch
+// that inserted it; the batch overwriting it records the previous values in an OverwrittenRow.
+var overwrittenColumns = []string{"date", "amount_cents", "currency", "description", "category", "anomalies"}
+
+// ParseConflictPolicy validates a conflict policy, falling back to IMPORT_CONFLICT_POLICY or skip when empty.
+func ParseConflictPolicy(policy string) (string, error) {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	if policy == "" {
+		policy = strings.ToLower(os.Getenv("IMPORT_CONFLICT_POLICY"))
+	}
+	if policy == "" {
+		policy = ConflictSkip
+	}
+
+	if policy != ConflictSkip && policy != ConflictOverwrite && policy != ConflictFail {
+		return "", fmt.Errorf("política de conflictos inválida: %s", policy)
+	}
+	return policy, nil
+}
+
+type (
+	// rowImporter streams CSV rows into the database, buffering at most one batch of rows
+	// so memory stays bounded regardless of the size of the file.
+	rowImporter struct {
+		tx         *gorm.DB            // Transaction wrapping the whole import
+		batch      *models.ImportBatch // Batch the rows are imported under
+		policy     string              // PolicyStrict or PolicyLenient
+		conv       rowConverter        // Converts raw rows into SQLDocuments
+		report     *ImportReport       // Outcome of every row
+		batchSize  int                 // Rows per multi-row INSERT
+		pending    []pendingRow        // Rows waiting for the next INSERT
+		pendingId  map[uint]int        // Position in pending of each IdTransaction
+		onConflict string              // ConflictSkip, ConflictOverwrite or ConflictFail
+		progress   func(rows int)      // Optional callback reporting the rows read after each flush
+	}
+
+	// pendingRow is a converted row waiting to be inserted, with the line it came from.
+	pendingRow struct {
+		line int                // Line number in the file
+		doc  models.SQLDocument // Row to insert
+	}
+)
+
+// importRows streams the remaining CSV rows into the database under the given batch in a single
+// database transaction, so a failed import never leaves a partially imported ledger.
+func importRows(reader *csv.Reader, batch *models.ImportBatch, policy, onConflict string, conv rowConverter, report *ImportReport, progress func(rows int)) error {
+	batchSize, err := config.GetEnvInt("IMPORT_BATCH_SIZE", defaultBatchSize)
+	if err != nil {
+		return err
+	}
+	if batchSize == 0 {
+		batchSize = 1 // Insert row by row
+	}
+	maxRows, err := config.GetEnvInt("IMPORT_MAX_ROWS", defaultMaxRows)
+	if err != nil {
+		return err
+	}
+	reader.ReuseRecord = true // Rows are converted before the next one is read
+
+	err = config.GetDB().Transaction(func(tx *gorm.DB) error {
+		imp := &rowImporter{
+			tx:         tx,
+			batch:      batch,
+			policy:     policy,
+			conv:       conv,
+			report:     report,
+			batchSize:  batchSize,
+			pendingId:  map[uint]int{},
+			onConflict: onConflict,
+			progress:   progress,
+		}
+
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				// Malformed quoting only invalidates the current record
+				batch.TotalRows++
+				if err := imp.reject(&RowError{Line: parseErr.StartLine, Reason: parseErr.Err.Error()}); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error al leer las filas: %v", err)
+			}
+
+			batch.TotalRows++
+			if maxRows > 0 && batch.TotalRows > maxRows {
+				return fmt.Errorf("el archivo excede el máximo de %d filas", maxRows)
+			}
+
+			line, _ := reader.FieldPos(0)
+			if err := imp.add(line, row); err != nil {
+				return err
+			}
+			if batch.TotalRows%batchSize == 0 {
+				imp.reportProgress()
+			}
+		}
+		if err := imp.flush(); err != nil {
+			return err
+		}
+		imp.reportProgress()
+		return nil
+	})
+	if err != nil {
+		// Everything was rolled back
+		report.Accepted = 0
+		report.Overwritten = 0
+		report.Flagged = 0
+	}
+
+	batch.ImportedRows = report.Accepted + report.Overwritten
+	batch.SkippedRows = batch.TotalRows - batch.ImportedRows
+	return err
+}
+
+// add converts a row and queues it for insertion, flushing the queue once a batch is full.
+// Bad rows abort the import under PolicyStrict and are skipped under PolicyLenient.
+func (imp *rowImporter) add(line int, row []string) error {
+	sqlDoc, err := imp.conv.rowToSQL(row)
+	if err != nil {
+		return imp.reject(asRowError(err, line))
+	}
+	sqlDoc.AccountId = imp.batch.AccountId
+	sqlDoc.ImportBatchId = imp.batch.Id
+
+	// A transaction repeated within the batch conflicts with the queued row
+	if i, ok := imp.pendingId[sqlDoc.IdTransaction]; ok {
+		switch imp.onConflict {
+		case ConflictSkip:
+			imp.report.SkippedDuplicates++
+		case ConflictOverwrite:
+			imp.pending[i] = pendingRow{line: line, doc: sqlDoc}
+			imp.report.Superseded++ // The queued row is never stored
+		default:
+			return imp.fail(conflictError(line, sqlDoc.IdTransaction))
+		}
+		return nil
+	}
+
+	imp.pendingId[sqlDoc.IdTransaction] = len(imp.pending)
+	imp.pending = append(imp.pending, pendingRow{line: line, doc: sqlDoc})
+	if len(imp.pending) >= imp.batchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+// flush upserts the pending rows with a single multi-row INSERT, resolving conflicts with the
+// rows already in the ledger according to the conflict policy. One SELECT per batch finds the
+// conflicting rows so the report can tell inserted, overwritten and skipped rows apart, while the
+// (account_id, id_transaction) unique index keeps concurrent uploads from storing duplicates.
+// Under PolicyLenient a failed INSERT is retried row by row so only the offending rows are skipped.
+func (imp *rowImporter) flush() error {
+	if len(imp.pending) == 0 {
+		return nil
+	}
+	defer imp.reset()
+
+	existing, own, err := imp.existingIds()
+	if err != nil {
+		return err
+	}
+
+	rows := make([]pendingRow, 0, len(imp.pending))
+	for _, p := range imp.pending {
+		if existing[p.doc.IdTransaction] {
+			switch imp.onConflict {
+			case ConflictSkip:
+				imp.report.SkippedDuplicates++
+				continue
+			case ConflictFail:
+				return imp.fail(conflictError(p.line, p.doc.IdTransaction))
+			}
+		}
+		rows = append(rows, p)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	docs := make([]models.SQLDocument, len(rows))
+	for i, p := range rows {
+		docs[i] = p.doc
+	}
+	if err := anomaly.FlagDuplicates(imp.tx, imp.batch.AccountId, docs); err != nil {
+		return err
+	}
+	if imp.onConflict == ConflictOverwrite {
+		if err := imp.recordOverwrites(rows, existing); err != nil {
+			return err
+		}
+	}
+
+	err = imp.insert().Create(&docs).Error
+	if err == nil {
+		for _, doc := range docs {
+			imp.count(existing[doc.IdTransaction], own[doc.IdTransaction], doc.Anomalies != "")
+		}
+		return nil
+	}
+	if imp.policy == PolicyStrict {
+		return fmt.Errorf("error al insertar las filas de las líneas %d a %d: %v", rows[0].line, rows[len(rows)-1].line, err)
+	}
+
+	log.Println("Batch insert failed, retrying row by row:", err)
+	for i, p := range rows {
+		doc := docs[i]
+		if err := imp.insert().Create(&doc).Error; err != nil {
+			if err := imp.reject(&RowError{Line: p.line, Reason: fmt.Sprintf("error al crear la transacción: %v", err)}); err != nil {
+				return err
+			}
+			continue
+		}
+		imp.count(existing[doc.IdTransaction], own[doc.IdTransaction], doc.Anomalies != "")
+	}
+	return nil
+}
+
+// existingIds returns the IdTransaction values of the pending rows already stored in the ledger,
+// and those among them stored by earlier batches of rows of this import.
+func (imp *rowImporter) existingIds() (existing, own map[uint]bool, err error) {
+	ids := make([]uint, len(imp.pending))
+	for i, p := range imp.pending {
+		ids[i] = p.doc.IdTransaction
+	}
+
+	var found []struct {
+		IdTransaction uint
+		ImportBatchId uint
+	}
+	err = imp.tx.Model(&models.SQLDocument{}).
+		Select("id_transaction, import_batch_id").
+		Where("account_id = ? AND id_transaction IN ?", imp.batch.AccountId, ids).
+		Scan(&found).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("error al buscar transacciones existentes: %v", err)
+	}
+
+	existing = make(map[uint]bool, len(found))
+	own = map[uint]bool{}
+	for _, f := range found {
+		existing[f.IdTransaction] = true
+		if f.ImportBatchId == imp.batch.Id {
+			own[f.IdTransaction] = true
+		}
+	}
+	return existing, own, nil
+}
+
+// recordOverwrites keeps the current values of the stored rows about to be overwritten, so that
+// reverting the import restores them. Rows inserted earlier by the same import are left out, since
+// reverting it deletes them.
+func (imp *rowImporter) recordOverwrites(rows []pendingRow, existing map[uint]bool) error {
+	var ids []uint
+	for _, p := range rows {
+		if existing[p.doc.IdTransaction] {
+			ids = append(ids, p.doc.IdTransaction)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var stored []struct {
+		Id          uint
+		Date        time.Time
+		Amount      money.Amount
+		Currency    string
+		Description string
+		Category    string
+		Anomalies   string
+	}
+	err := imp.tx.Model(&models.SQLDocument{}).
+		Select("id, date, amount_cents AS amount, currency, description, category, anomalies").
+		Where("account_id = ? AND id_transaction IN ? AND import_batch_id <> ?", imp.batch.AccountId, ids, imp.batch.Id).
+		Scan(&stored).Error
+	if err != nil {
+		return fmt.Errorf("error al buscar las transacciones a sobrescribir: %v", err)
+	}
+	if len(stored) == 0 {
+		return nil
+	}
+
+	previous := make([]models.OverwrittenRow, len(stored))
+	for i, row := range stored {
+		previous[i] = models.OverwrittenRow{
+			ImportBatchId: imp.batch.Id,
+			SQLDocumentId: row.Id,
+			Date:          row.Date,
+			Transaction:   row.Amount,
+			Currency:      row.Currency,
+			Description:   row.Description,
+			Category:      row.Category,
+			Anomalies:     row.Anomalies,
+		}
+	}
+
+	// Keep the values from before the first overwrite when a file overwrites a row twice
+	if err := imp.tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&previous).Error; err != nil {
+		return fmt.Errorf("error al guardar los valores anteriores de las transacciones: %v", err)
+	}
+	return nil
+}
+
+// insert returns the statement used to store rows, with the ON CONFLICT clause of the policy.
+// ConflictFail uses a plain INSERT so the unique index rejects duplicates.
+func (imp *rowImporter) insert() *gorm.DB {
+	columns := []clause.Column{{Name: "account_id"}, {Name: "id_transaction"}}
+
+	switch imp.onConflict {
+	case ConflictSkip:
+		return imp.tx.Clauses(clause.OnConflict{Columns: columns, DoNothing: true})
+	case ConflictOverwrite:
+		return imp.tx.Clauses(clause.OnConflict{Columns: columns, DoUpdates: clause.AssignmentColumns(overwrittenColumns)})
+	default:
+		return imp.tx
+	}
+}
+
+// count records a stored row as superseded when it replaced a row stored earlier by the same
+// import, which was counted already, as overwritten when it replaced an existing one, or as
+// accepted, and as flagged when it has anomaly flags.
+func (imp *rowImporter) count(overwritten, own, flagged bool) {
+	switch {
+	case own:
+		imp.report.Superseded++
+		return
+	case overwritten:
+		imp.report.Overwritten++
+	default:
+		imp.report.Accepted++
+	}
+	if flagged {
+		imp.report.Flagged++
+	}
+}
+
+// reset empties the queue of pending rows.
+func (imp *rowImporter) reset() {
+	imp.pending = imp.pending[:0]
+	clear(imp.pendingId)
+}
+
+// reject records a row that can't be imported. Under PolicyStrict it returns the error to abort
+// the import; only the first maxReportedErrors errors are kept in the report.
+func (imp *rowImporter) reject(rowErr *RowError) error {
+	imp.report.Rejected++
+	if len(imp.report.Errors) < maxReportedErrors {
+		imp.report.Errors = append(imp.report.Errors, *rowErr)
+	} else {
+		imp.report.ErrorsTruncated = true
+	}
+
+	if imp.policy == PolicyStrict {
+		return rowErr
+	}
+	return nil
+}
+
+// reportProgress tells the progress callback, if any, how many rows were read so far.
+func (imp *rowImporter) reportProgress() {
+	if imp.progress != nil {
+		imp.progress(imp.batch.TotalRows)
+	}
+}
+
+// fail records a row that aborts the import regardless of the import policy.
+func (imp *rowImporter) fail(rowErr *RowError) error {
+	if err := imp.reject(rowErr); err != nil {
+		return err
+	}
+	return rowErr
+}
+
+// conflictError describes a row whose IdTransaction is already in the ledger.
+func conflictError(line int, idTransaction uint) *RowError {
+	return &RowError{Line: line, Column: FieldId, Reason: fmt.Sprintf("la transacción %d ya existe", idTransaction)}
+}
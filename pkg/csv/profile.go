@@ -0,0 +1,230 @@
+package csv
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Fields of a transaction that a Profile maps to the columns of a file.
+const (
+	FieldId          = "Id"          // Transaction ID, required
+	FieldDate        = "Date"        // Transaction date, required
+	FieldTransaction = "Transaction" // Transaction amount, required
+	FieldCurrency    = "Currency"    // Currency of the amount, optional
+	FieldDescription = "Description" // Description or merchant of the transaction, optional
+)
+
+var (
+	// requiredFields lists the fields every file has to provide.
+	requiredFields = []string{FieldId, FieldDate, FieldTransaction}
+	// knownFields lists every field a Profile can map.
+	knownFields = []string{FieldId, FieldDate, FieldTransaction, FieldCurrency, FieldDescription}
+)
+
+// ErrUnknownProfile is returned when an upload asks for an import profile that isn't configured.
+var ErrUnknownProfile = errors.New("perfil de importación desconocido")
+
+// Profile describes the dialect and column layout of a bank export so it can be imported as is.
+type Profile struct {
+	Name               string              `json:"name"`               // Name used in the `profile` form field
+	Delimiter          string              `json:"delimiter"`          // Field delimiter, a single character such as "," or "\t" for tabs
+	LazyQuotes         bool                `json:"lazyQuotes"`         // Accept quotes appearing in unquoted fields
+	DecimalComma       bool                `json:"decimalComma"`       // Amounts use a comma as decimal separator, e.g. 1234,56
+	ThousandsSeparator string              `json:"thousandsSeparator"` // Separator to strip from amounts, e.g. "." in 1.234,56
+	DayFirst           bool                `json:"dayFirst"`           // Slash dates are written D/M or D/M/YYYY
+	Columns            map[string][]string `json:"columns"`            // Header names accepted for each Field* value
+}
+
+// columnMap holds the position of each field in the rows of a file.
+type columnMap struct {
+	width   int            // Number of columns declared by the header
+	indexes map[string]int // Position of each mapped field
+}
+
+// DefaultProfile is used when an upload doesn't select a profile. It accepts our own export format.
+var DefaultProfile = Profile{
+	Name:      "default",
+	Delimiter: ",",
+	Columns: map[string][]string{
+		FieldId:          {"Id"},
+		FieldDate:        {"Date"},
+		FieldTransaction: {"Transaction"},
+		FieldCurrency:    {"Currency"},
+		FieldDescription: {"Description", "Merchant"},
+	},
+}
+
+var (
+	profiles   map[string]Profile // Profiles of IMPORT_PROFILES_FILE, nil until loaded
+	profilesMu sync.Mutex         // Guards profiles
+)
+
+// LoadProfiles loads the import profiles of the IMPORT_PROFILES_FILE JSON file, unless they are
+// already loaded. A failed load isn't kept, so the next call tries again; main loads the profiles
+// on startup to refuse an invalid file.
+func LoadProfiles() error {
+	_, err := cachedProfiles()
+	return err
+}
+
+// cachedProfiles returns the profiles of IMPORT_PROFILES_FILE, loading them on the first successful call.
+func cachedProfiles() (map[string]Profile, error) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	if profiles == nil {
+		loaded, err := loadProfiles(os.Getenv("IMPORT_PROFILES_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		profiles = loaded
+	}
+	return profiles, nil
+}
+
+// LookupProfile returns the import profile with the given name. An empty name selects
+// DefaultProfile; other profiles come from IMPORT_PROFILES_FILE, see LoadProfiles, whose errors are returned.
+func LookupProfile(name string) (Profile, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == DefaultProfile.Name {
+		return DefaultProfile, nil
+	}
+
+	loaded, err := cachedProfiles()
+	if err != nil {
+		return Profile{}, err
+	}
+	profile, ok := loaded[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+	return profile, nil
+}
+
+// loadProfiles reads the import profiles from a JSON array, indexing them by name.
+func loadProfiles(path string) (map[string]Profile, error) {
+	loaded := map[string]Profile{}
+	if path == "" {
+		return loaded, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return loaded, fmt.Errorf("no se pudo leer el archivo de perfiles: %v", err)
+	}
+
+	var list []Profile
+	if err := json.Unmarshal(data, &list); err != nil {
+		return loaded, fmt.Errorf("archivo de perfiles inválido: %v", err)
+	}
+	for _, profile := range list {
+		if err := profile.validate(); err != nil {
+			return map[string]Profile{}, err
+		}
+		loaded[profile.Name] = profile
+	}
+	return loaded, nil
+}
+
+// validate checks that the profile can be used to read a file: a name, a delimiter encoding/csv
+// accepts, separators that can't be confused, known fields and the required columns.
+func (p Profile) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("perfil sin nombre")
+	}
+
+	// The delimiter must be a character encoding/csv accepts as one
+	delimiter, size := utf8.DecodeRuneInString(p.Delimiter)
+	if size == 0 || size != len(p.Delimiter) || delimiter == utf8.RuneError || delimiter == 0 ||
+		delimiter == '"' || delimiter == '\r' || delimiter == '\n' {
+		return fmt.Errorf("perfil %s: el delimitador debe ser un solo carácter distinto de comillas y saltos de línea", p.Name)
+	}
+
+	// Amounts must be split unambiguously into their fields and their parts
+	decimal := "."
+	if p.DecimalComma {
+		decimal = ","
+	}
+	if decimal == p.Delimiter {
+		return fmt.Errorf("perfil %s: el separador decimal no puede ser el delimitador", p.Name)
+	}
+	if p.ThousandsSeparator == decimal || p.ThousandsSeparator == p.Delimiter {
+		return fmt.Errorf("perfil %s: el separador de miles no puede ser el separador decimal ni el delimitador", p.Name)
+	}
+
+	for field := range p.Columns {
+		if !slices.Contains(knownFields, field) {
+			return fmt.Errorf("perfil %s: columna desconocida %s", p.Name, field)
+		}
+	}
+	for _, field := range requiredFields {
+		if len(p.Columns[field]) == 0 {
+			return fmt.Errorf("perfil %s: falta la columna %s", p.Name, field)
+		}
+	}
+	return nil
+}
+
+// configure applies the profile's dialect to a CSV reader.
+func (p Profile) configure(reader *csv.Reader) {
+	if p.Delimiter != "" {
+		reader.Comma, _ = utf8.DecodeRuneInString(p.Delimiter)
+	}
+	reader.LazyQuotes = p.LazyQuotes
+}
+
+// mapHeader locates the profile's fields in the header of a file. Header names are matched
+// ignoring case and surrounding spaces; columns the profile doesn't know are ignored.
+func (p Profile) mapHeader(headers []string) (columnMap, error) {
+	columns := columnMap{width: len(headers), indexes: map[string]int{}}
+
+	for i, header := range headers {
+		header = strings.TrimSpace(strings.TrimPrefix(header, "\ufeff")) // Drop a UTF-8 BOM
+		for field, names := range p.Columns {
+			for _, name := range names {
+				if !strings.EqualFold(header, name) {
+					continue
+				}
+				if _, seen := columns.indexes[field]; seen {
+					return columnMap{}, fmt.Errorf("cabecera inválida: la columna %s aparece más de una vez", field)
+				}
+				columns.indexes[field] = i
+			}
+		}
+	}
+
+	for _, field := range requiredFields {
+		if _, ok := columns.indexes[field]; !ok {
+			return columnMap{}, fmt.Errorf("cabecera inválida: falta la columna %s (%s)", field, strings.Join(p.Columns[field], ", "))
+		}
+	}
+	return columns, nil
+}
+
+// value returns the content of field in row, or an empty string when the file doesn't have it.
+func (c columnMap) value(row []string, field string) string {
+	i, ok := c.indexes[field]
+	if !ok {
+		return ""
+	}
+	return row[i]
+}
+
+// normalizeAmount rewrites an amount written in the profile's notation as a plain decimal.
+func (p Profile) normalizeAmount(amount string) string {
+	amount = strings.TrimSpace(amount)
+	if p.ThousandsSeparator != "" {
+		amount = strings.ReplaceAll(amount, p.ThousandsSeparator, "")
+	}
+	if p.DecimalComma {
+		amount = strings.Replace(amount, ",", ".", 1)
+	}
+	return amount
+}
@@ -0,0 +1,56 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidFile is returned when the uploaded file is not a transactions CSV, e.g. its header is wrong.
+var ErrInvalidFile = errors.New("archivo CSV inválido")
+
+type (
+	// ImportReport summarizes the outcome of importing a CSV file.
+	ImportReport struct {
+		ImportId          uint       `json:"importId"`          // ID of the ImportBatch recording the upload
+		ConflictPolicy    string     `json:"conflictPolicy"`    // Policy applied to rows already in the ledger
+		Accepted          int        `json:"accepted"`          // Number of new rows stored in the ledger
+		Overwritten       int        `json:"overwritten"`       // Number of stored rows replaced under ConflictOverwrite
+		SkippedDuplicates int        `json:"skippedDuplicates"` // Number of rows skipped under ConflictSkip
+		Superseded        int        `json:"superseded"`        // Number of rows replaced by a later line of the file with the same ID under ConflictOverwrite
+		Rejected          int        `json:"rejected"`          // Number of rows that could not be imported
+		Flagged           int        `json:"flagged"`           // Number of stored rows flagged as anomalies, see the anomaly package
+		Errors            []RowError `json:"errors"`            // Rows that could not be imported, capped at maxReportedErrors
+		ErrorsTruncated   bool       `json:"errorsTruncated"`   // Errors only lists the first rejected rows
+	}
+
+	// RowError describes why a single line of the CSV file could not be imported.
+	RowError struct {
+		Line   int    `json:"line"`             // Line number in the file, the header being line 1
+		Column string `json:"column,omitempty"` // Column holding the invalid value, empty when it applies to the whole row
+		Reason string `json:"reason"`           // Human readable cause of the error
+	}
+)
+
+// Error implements the error interface.
+func (e *RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("línea %d: %s", e.Line, e.Reason)
+	}
+	return fmt.Sprintf("línea %d, columna %s: %s", e.Line, e.Column, e.Reason)
+}
+
+// newRowError creates a RowError for the given column; line is filled in by the caller.
+func newRowError(column, format string, args ...any) *RowError {
+	return &RowError{Column: column, Reason: fmt.Sprintf(format, args...)}
+}
+
+// asRowError converts err into a RowError located at the given line.
+func asRowError(err error, line int) *RowError {
+	var rowErr *RowError
+	if errors.As(err, &rowErr) {
+		located := *rowErr
+		located.Line = line
+		return &located
+	}
+	return &RowError{Line: line, Reason: err.Error()}
+}
@@ -1,98 +1,136 @@
 package email
 
 import (
-	"bytes"
 	"fmt"
 	"log"
-	"net/smtp"
+	"net/mail"
 	"os"
-	"path/filepath"
 	"regexp"
+	"stori_challenge/pkg/i18n"
 	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/statement"
 	"strings"
-	"text/template"
-
-	"github.com/joho/godotenv"
+	"time"
 )
 
-// SendEmail sends an email using the SMTP protocol with the given EmailData.
-func SendEmail(data models.EmailData) error {
-	// Path to the .env file in the root of the project
-	envPath := filepath.Join(".", ".env")
-
-	// Load the .env file
-	err := godotenv.Load(envPath)
-	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+// SendEmail renders the summary in EmailData as a MIME message, with HTML and plain text bodies
+// and the statements requested in EmailData.Attachments, and delivers it with the given Mailer.
+func SendEmail(mailer Mailer, data models.EmailData) error {
+	// Refuse to render a summary nobody can receive
+	if !IsValidEmail(data.EmailTo) {
+		return fmt.Errorf("invalid recipient email address: %q", data.EmailTo)
 	}
 
-	// SMTP server configuration
-	smtpServer := os.Getenv("SMTP_SERVER")     // SMTP server address
-	smtpPort := os.Getenv("SMTP_PORT")         // SMTP server port
-	senderEmail := os.Getenv("SMTP_SENDER")    // Sender's email address
-	senderPassword := os.Getenv("SMTP_PASSWD") // Sender's email password
-
-	// Validate environment variables for SMTP configuration
-	if smtpServer == "" || smtpPort == "" || senderEmail == "" || senderPassword == "" {
-		return fmt.Errorf("missing SMTP configuration in environment variables")
+	// Sender's email address, the author of the message
+	senderEmail := os.Getenv("SMTP_SENDER")
+	if senderEmail == "" {
+		return fmt.Errorf("missing SMTP_SENDER in environment variables")
 	}
 
-	// Authenticate with the SMTP server
-	auth := smtp.PlainAuth("", senderEmail, senderPassword, smtpServer)
-
-	// External HTML template file for the email body
-	templateFile := filepath.Join("web", "template", "email_template.html")
+	// CC (carbon copy) recipients, a comma separated list of addresses
+	var cc []*mail.Address
+	if list := os.Getenv("SMTP_CC"); list != "" {
+		var err error
+		if cc, err = mail.ParseAddressList(list); err != nil {
+			return fmt.Errorf("invalid SMTP_CC: %w", err)
+		}
+	}
 
-	// Parse the HTML template file
-	t, err := template.ParseFiles(templateFile)
+	// Render the plain text and HTML bodies from the templates of the email's locale
+	text, err := RenderText(data)
 	if err != nil {
-		return fmt.Errorf("failed to parse email template: %w", err)
+		return err
 	}
-
-	// Create a buffer to hold the output of the template
-	var tpl bytes.Buffer
-	if err := t.Execute(&tpl, data); err != nil {
-		return fmt.Errorf("failed to execute email template: %w", err)
+	html, err := RenderHTML(data)
+	if err != nil {
+		return err
 	}
 
-	// Email message in HTML format
-	htmlMessage := tpl.String()
-
-	// List of email recipients
-	to := []string{os.Getenv("SMTP_SENDER")} // Add sender's email to recipients
-	to = append(to, data.EmailTo)            // Add recipient's email
-
-	// CC (carbon copy) recipients
-	cc := os.Getenv("SMTP_CC")
-	ccEmails := []string{}
-	if cc != "" {
-		ccEmails = append(ccEmails, cc) // Add CC emails if provided
+	subject, err := emailSubject(data)
+	if err != nil {
+		return err
 	}
 
-	// Subject of the email
-	subject := os.Getenv("SMTP_SUBJECT")
+	sender := mail.Address{Name: os.Getenv("SMTP_FROM_NAME"), Address: senderEmail}
+	m := Mail{
+		From:    sender,
+		To:      []mail.Address{{Address: data.EmailTo}},
+		Bcc:     []mail.Address{sender}, // Keep a copy for the sender without exposing it to the recipient
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	}
+	for _, a := range cc {
+		m.Cc = append(m.Cc, *a)
+	}
 
-	// Create the email body with headers
-	body := []byte(fmt.Sprintf("To: %s\r\nSubject: %s\r\nCc: %s\r\nMIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\r\n\r\n%s",
-		strings.Join(to, ","),       // Combine all To recipients
-		subject,                     // Subject line
-		strings.Join(ccEmails, ","), // Combine all CC recipients
-		htmlMessage))                // HTML message body
+	// Attach the requested statements, built from the same summary
+	for _, format := range data.Attachments {
+		file, err := statement.Render(format, data)
+		if err != nil {
+			return fmt.Errorf("failed to render the %s statement: %w", format, err)
+		}
+		m.Attachments = append(m.Attachments, Attachment{Filename: file.Name, ContentType: file.ContentType, Data: file.Data})
+	}
 
-	// Combine To and CC recipients for sending
-	recipients := append(to, ccEmails...)
+	msg, err := m.Message(time.Now())
+	if err != nil {
+		return err
+	}
 
 	// Send the email
-	if err = smtp.SendMail(smtpServer+":"+smtpPort, auth, senderEmail, recipients, body); err != nil {
+	if err := mailer.Send(msg); err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
 	// Log the successful email sending
-	log.Printf("Email successfully sent to: %s", strings.Join(recipients, ", "))
+	log.Printf("Email successfully sent to: %s", strings.Join(msg.To, ", "))
 
 	return nil
 }
 
+// RenderText renders the plain text body of the summary in the locale of EmailData.
+func RenderText(data models.EmailData) (string, error) {
+	locale, err := emailLocale(data)
+	if err != nil {
+		return "", err
+	}
+	return render("email_template", "txt", locale, data)
+}
+
+// RenderHTML renders the HTML body of the summary in the locale of EmailData, escaping the data for HTML.
+func RenderHTML(data models.EmailData) (string, error) {
+	locale, err := emailLocale(data)
+	if err != nil {
+		return "", err
+	}
+	return render("email_template", "html", locale, data)
+}
+
+// emailLocale returns the language of the email, the default locale when the summary doesn't specify one.
+func emailLocale(data models.EmailData) (string, error) {
+	locale, err := i18n.Parse(data.Locale)
+	if err != nil {
+		return "", fmt.Errorf("invalid email locale %q: %w", data.Locale, err)
+	}
+	return locale, nil
+}
+
+// emailSubject returns the subject of the email in its locale. SMTP_SUBJECT, when set, replaces
+// the subject of the emails written in the default locale, the language it is configured in.
+func emailSubject(data models.EmailData) (string, error) {
+	locale, err := emailLocale(data)
+	if err != nil {
+		return "", err
+	}
+	if subject := os.Getenv("SMTP_SUBJECT"); subject != "" {
+		if defaultLocale, err := i18n.Parse(""); err == nil && locale == defaultLocale {
+			return subject, nil
+		}
+	}
+	return i18n.Text(locale, "subject"), nil
+}
+
 // IsValidEmail validates the format of an email address using a regular expression.
 func IsValidEmail(email string) bool {
 	// Regular expression to validate the email format
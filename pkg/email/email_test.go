@@ -1,8 +1,19 @@
 package email
 
 import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
 	"stori_challenge/pkg/models"
+	"strings"
 	"testing"
+	"time"
 )
 
 // testPair defines a structure for holding test case information,
@@ -17,24 +28,24 @@ var tests = []testPair{
 	{
 		models.EmailData{
 			EmailTo:             "hector.gonzalez.olmos@gmail.com", // Valid email address
-			TotalBalance:        100.0,                             // Total balance
-			AverageDebitAmount:  50.0,                              // Average debit amount
-			AverageCreditAmount: 150.0,                             // Average credit amount
+			TotalBalance:        10000,                             // Total balance in cents
+			AverageDebitAmount:  5000,                              // Average debit amount in cents
+			AverageCreditAmount: 15000,                             // Average credit amount in cents
 			Transactions: []models.TransactionsByMonth{
-				{Total: 5, Month: "January"},   // Transactions for January
-				{Total: 10, Month: "February"}, // Transactions for February
+				{Total: 5, Year: 2024, Month: time.January, Net: 2500},   // Transactions for January
+				{Total: 10, Year: 2024, Month: time.February, Net: -800}, // Transactions for February
 			},
 		}, false, // Expecting no error for this case
 	},
 	{
 		models.EmailData{
 			EmailTo:             "invalid-email", // Invalid email address
-			TotalBalance:        100.0,           // Total balance
-			AverageDebitAmount:  50.0,            // Average debit amount
-			AverageCreditAmount: 150.0,           // Average credit amount
+			TotalBalance:        10000,           // Total balance in cents
+			AverageDebitAmount:  5000,            // Average debit amount in cents
+			AverageCreditAmount: 15000,           // Average credit amount in cents
 			Transactions: []models.TransactionsByMonth{
-				{Total: 5, Month: "January"},   // Transactions for January
-				{Total: 10, Month: "February"}, // Transactions for February
+				{Total: 5, Year: 2024, Month: time.January, Net: 2500},   // Transactions for January
+				{Total: 10, Year: 2024, Month: time.February, Net: -800}, // Transactions for February
 			},
 		}, true, // Expecting an error for this case
 	},
@@ -42,14 +53,294 @@ var tests = []testPair{
 
 // TestSendEmail tests the SendEmail function with various inputs
 func TestSendEmail(t *testing.T) {
+	// Render the embedded templates and record the messages instead of sending them
+	t.Setenv("SMTP_SENDER", "sender@example.com")
+	mailer := &MemoryMailer{}
+
+	sent := 0
 	for _, pair := range tests {
 		// Call the SendEmail function with the current test case data
-		err := SendEmail(pair.data)
+		err := SendEmail(mailer, pair.data)
 
 		// Check if the error result matches the expected outcome
 		if (err != nil) != pair.hasErr {
 			// Log an error if the actual result does not match the expected result
 			t.Errorf("For %+v expected error: %v, got error: %v", pair.data, pair.hasErr, err)
 		}
+		if err == nil {
+			sent++
+		}
+	}
+
+	// Only the valid emails reach the mailer
+	messages := mailer.Messages()
+	if len(messages) != sent {
+		t.Fatalf("expected %d messages, got %d", sent, len(messages))
+	}
+	if messages[0].From != "sender@example.com" || !slices.Equal(messages[0].To, []string{"hector.gonzalez.olmos@gmail.com", "sender@example.com"}) {
+		t.Errorf("unexpected envelope: from %s to %v", messages[0].From, messages[0].To)
+	}
+}
+
+// TestFileMailer tests that messages are written as .eml files or delivered to a Maildir
+func TestFileMailer(t *testing.T) {
+	msg := Message{From: "sender@example.com", To: []string{"to@example.com"}, Data: []byte("Subject: Test\r\n\r\nBody")}
+
+	for _, maildir := range []bool{false, true} {
+		dir := t.TempDir()
+		mailer := &FileMailer{Dir: dir, Maildir: maildir}
+		if err := mailer.Send(msg); err != nil {
+			t.Fatalf("Send (maildir %v): unexpected error %v", maildir, err)
+		}
+
+		// Maildir messages end up in new/ and nothing is left in tmp/
+		pattern := filepath.Join(dir, "*.eml")
+		if maildir {
+			pattern = filepath.Join(dir, "new", "*")
+			if tmp, _ := filepath.Glob(filepath.Join(dir, "tmp", "*")); len(tmp) != 0 {
+				t.Errorf("expected an empty tmp/, found %v", tmp)
+			}
+		}
+		files, _ := filepath.Glob(pattern)
+		if len(files) != 1 {
+			t.Fatalf("maildir %v: expected 1 message, found %v", maildir, files)
+		}
+		data, err := os.ReadFile(files[0])
+		if err != nil || string(data) != string(msg.Data) {
+			t.Errorf("maildir %v: unexpected message %q (%v)", maildir, data, err)
+		}
+	}
+}
+
+// TestNewMailer tests the selection of the mail transport
+func TestNewMailer(t *testing.T) {
+	t.Setenv("MAIL_TRANSPORT", TransportMemory)
+	if m, err := NewMailer(); err != nil {
+		t.Errorf("memory transport: unexpected error %v", err)
+	} else if _, ok := m.(*MemoryMailer); !ok {
+		t.Errorf("memory transport: got %T", m)
+	}
+
+	t.Setenv("MAIL_TRANSPORT", TransportMaildir)
+	t.Setenv("MAIL_DIR", "")
+	if _, err := NewMailer(); err == nil {
+		t.Error("maildir transport without MAIL_DIR: expected an error")
+	}
+
+	t.Setenv("MAIL_TRANSPORT", TransportSMTP)
+	t.Setenv("SMTP_SERVER", "smtp.example.com")
+	t.Setenv("SMTP_PORT", "465")
+	t.Setenv("SMTP_SECURITY", "ssl3")
+	if _, err := NewMailer(); err == nil {
+		t.Error("invalid SMTP security mode: expected an error")
+	}
+
+	t.Setenv("MAIL_TRANSPORT", "pigeon")
+	if _, err := NewMailer(); err == nil {
+		t.Error("unknown transport: expected an error")
+	}
+}
+
+// TestMailMessage tests that messages are valid MIME with encoded headers and hidden Bcc recipients
+func TestMailMessage(t *testing.T) {
+	m := Mail{
+		From:    mail.Address{Name: "Stori", Address: "sender@example.com"},
+		To:      []mail.Address{{Name: "Héctor González", Address: "to@example.com"}},
+		Bcc:     []mail.Address{{Address: "sender@example.com"}},
+		Subject: "Resumen de transacciones, Héctor",
+		Text:    "Total balance is: 39.74 USD\nAverage debit amount: -15.38 USD",
+		HTML:    "<p>Total balance is: 39.74 USD</p>",
+	}
+	msg, err := m.Message(time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Message: unexpected error %v", err)
+	}
+
+	// The envelope includes the Bcc recipient, the headers don't
+	if !slices.Equal(msg.To, []string{"to@example.com", "sender@example.com"}) {
+		t.Errorf("envelope recipients = %v", msg.To)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: unexpected error %v", err)
+	}
+	if to := parsed.Header.Get("To"); strings.Contains(to, "sender@example.com") {
+		t.Errorf("To header exposes the Bcc recipient: %s", to)
+	}
+	if bcc := parsed.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("unexpected Bcc header: %s", bcc)
+	}
+	for _, name := range []string{"From", "Date", "Message-ID"} {
+		if parsed.Header.Get(name) == "" {
+			t.Errorf("missing %s header", name)
+		}
+	}
+
+	// Non-ASCII headers are encoded and decode back to the original text
+	subject := parsed.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err != nil || decoded != m.Subject || subject == m.Subject {
+		t.Errorf("Subject header %q decodes to %q (%v)", subject, decoded, err)
+	}
+	if to, err := parsed.Header.AddressList("To"); err != nil || to[0].Name != "Héctor González" {
+		t.Errorf("To header decodes to %v (%v)", to, err)
+	}
+
+	// The body holds the plain text and HTML alternatives, in that order
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %s (%v)", mediaType, err)
+	}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, expected := range []struct{ mediaType, content string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		part, err := reader.NextPart() // Decodes quoted-printable transparently
+		if err != nil {
+			t.Fatalf("NextPart: unexpected error %v", err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		content, _ := io.ReadAll(part)
+		if partType != expected.mediaType || strings.ReplaceAll(string(content), "\r\n", "\n") != expected.content {
+			t.Errorf("part %s = %q, expected %s %q", partType, content, expected.mediaType, expected.content)
+		}
+	}
+}
+
+// TestSendEmailWithStatements tests that requested statements are attached after the bodies
+func TestSendEmailWithStatements(t *testing.T) {
+	t.Setenv("SMTP_SENDER", "sender@example.com")
+	mailer := &MemoryMailer{}
+
+	data := tests[0].data
+	data.Attachments = []string{"csv", "pdf"}
+	data.Entries = []models.StatementEntry{{Date: "2024-07-15", IdTransaction: 1, Amount: 6000, Currency: "USD"}}
+	if err := SendEmail(mailer, data); err != nil {
+		t.Fatalf("SendEmail: unexpected error %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(mailer.Messages()[0].Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: unexpected error %v", err)
+	}
+	mediaType, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %s, expected multipart/mixed", mediaType)
+	}
+
+	var parts []string
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: unexpected error %v", err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts = append(parts, partType+" "+part.FileName())
+
+		// Attachments are base64 encoded and decode back to the statement
+		if part.FileName() == "statement_2024-07-15_2024-07-15.csv" {
+			content, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+			if string(content) != "Id,Date,Transaction,Currency,Description,Category\n1,2024-07-15,60.00,USD,,\n" {
+				t.Errorf("unexpected CSV attachment %q", content)
+			}
+		}
+	}
+
+	expected := []string{
+		"multipart/alternative ",
+		"text/csv statement_2024-07-15_2024-07-15.csv",
+		"application/pdf statement_2024-07-15_2024-07-15.pdf",
+	}
+	if !slices.Equal(parts, expected) {
+		t.Errorf("parts = %v, expected %v", parts, expected)
+	}
+}
+
+// TestSendEmailLocalized tests that the templates and formats of the email's locale are used
+func TestSendEmailLocalized(t *testing.T) {
+	t.Setenv("SMTP_SENDER", "sender@example.com")
+	t.Setenv("SMTP_SUBJECT", "Custom subject") // Only replaces the subject of the default locale
+	t.Setenv("DEFAULT_LOCALE", "")
+	subjects := map[string]string{"es": "Resumen de tu cuenta", "en": "Custom subject"}
+
+	data := tests[0].data
+	data.Currency = "MXN"
+	data.TotalBalance = 123456
+	data.Period = models.Period{From: "2024-01-01", To: "2024-02-29"}
+	data.Statistics.ClosingBalance = 200000
+	data.Categories = []models.TransactionsByCategory{
+		{Category: "Groceries", Total: 3, DebitTotal: -4550, Net: -4550},
+		{Category: "", Total: 1, CreditTotal: 1000, Net: 1000},
+	}
+	data.Recurring = []models.RecurringTransaction{
+		{Description: "Netflix", Currency: "MXN", Amount: -21900, Frequency: "monthly", Occurrences: 3, LastDate: "2024-02-15", NextDate: "2024-03-15"},
+	}
+	data.Anomalies = []models.FlaggedTransaction{
+		{Date: "2024-02-10", IdTransaction: 9, Description: "", Amount: -980000, Currency: "MXN", Flags: []string{"amount", "duplicate"}},
+	}
+	data.Alerts = []models.BudgetAlert{
+		{Category: "Groceries", Year: 2024, Month: time.January, Limit: 4000, Spent: 4550},
+	}
+	for locale, expected := range map[string][]string{
+		"es": {"Periodo: desde 2024-01-01 hasta 2024-02-29", "- Groceries en enero de 2024 llegó a 45,50 MXN, por encima del presupuesto de 40,00 MXN", "- 2024-02-10 Sin descripción -9.800,00 MXN: monto inusual, posible duplicado", "Saldo total: 1.234,56 MXN", "Saldo al cierre: 2.000,00 MXN", "Categoría Groceries: 3 transacciones (débitos -45,50 MXN, créditos 0,00 MXN)", "Categoría Sin categoría: 1 transacciones", "- Netflix: -219,00 MXN cada mes, próximo el 2024-03-15", "Número de transacciones en enero de 2024: 5 (neto 25,00 MXN)"},
+		"en": {"Period: from 2024-01-01 to 2024-02-29", "- Groceries in January 2024 reached 45.50 MXN, over the budget of 40.00 MXN", "- 2024-02-10 No description -9,800.00 MXN: unusual amount, possible duplicate", "Total balance is: 1,234.56 MXN", "Closing balance: 2,000.00 MXN", "Category Groceries: 3 transactions (debits -45.50 MXN, credits 0.00 MXN)", "Category Uncategorized: 1 transactions", "- Netflix: -219.00 MXN every month, next on 2024-03-15", "Number of transactions in January 2024: 5 (net 25.00 MXN)"},
+	} {
+		mailer := &MemoryMailer{}
+		data.Locale = locale
+		if err := SendEmail(mailer, data); err != nil {
+			t.Fatalf("SendEmail(%s): unexpected error %v", locale, err)
+		}
+
+		parsed, _ := mail.ReadMessage(bytes.NewReader(mailer.Messages()[0].Data))
+		if subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject")); subject != subjects[locale] {
+			t.Errorf("%s subject = %q, expected %q", locale, subject, subjects[locale])
+		}
+		_, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+		part, err := multipart.NewReader(parsed.Body, params["boundary"]).NextPart()
+		if err != nil {
+			t.Fatalf("NextPart: unexpected error %v", err)
+		}
+		text, _ := io.ReadAll(part)
+		for _, line := range expected {
+			if !strings.Contains(string(text), line) {
+				t.Errorf("%s text body doesn't contain %q:\n%s", locale, line, text)
+			}
+		}
+	}
+}
+
+// TestTemplateOverride tests that templates of EMAIL_TEMPLATE_DIR replace the embedded ones and
+// are reloaded when they change
+func TestTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("EMAIL_TEMPLATE_DIR", dir)
+	data := models.EmailData{Currency: "USD", TotalBalance: 3974, Locale: "es"}
+
+	// Without a Spanish override the Spanish embedded template is used
+	file := filepath.Join(dir, "email_template.txt")
+	if err := os.WriteFile(file, []byte("Balance: {{money .TotalBalance .Currency}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if text, err := RenderText(data); err != nil || !strings.HasPrefix(text, "Stori Challenge") {
+		t.Errorf("RenderText(es) = %q (%v), expected the embedded Spanish template", text, err)
+	}
+
+	// The English override replaces the embedded template
+	data.Locale = "en"
+	if text, err := RenderText(data); err != nil || text != "Balance: 39.74 USD" {
+		t.Errorf("RenderText(en) = %q (%v)", text, err)
+	}
+
+	// Editing the override is picked up without restarting
+	if err := os.WriteFile(file, []byte("Total: {{money .TotalBalance .Currency}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if text, err := RenderText(data); err != nil || text != "Total: 39.74 USD" {
+		t.Errorf("RenderText after editing = %q (%v)", text, err)
 	}
 }
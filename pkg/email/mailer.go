@@ -0,0 +1,263 @@
+package email
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mail transports selectable with MAIL_TRANSPORT.
+const (
+	TransportSMTP    = "smtp"    // Deliver through an SMTP server
+	TransportFile    = "file"    // Write every message as an .eml file to MAIL_DIR
+	TransportMaildir = "maildir" // Deliver to the Maildir at MAIL_DIR
+	TransportMemory  = "memory"  // Keep the messages in memory, for tests and development
+)
+
+// Security modes of the SMTP connection, selectable with SMTP_SECURITY.
+const (
+	SecurityStartTLS = "starttls" // Upgrade a plain connection with STARTTLS, usually on port 587
+	SecurityTLS      = "tls"      // Implicit TLS from the first byte, usually on port 465
+	SecurityNone     = "none"     // Plain connection, only for local relays
+)
+
+// Timeouts of the SMTP transport.
+const (
+	dialTimeout    = 30 * time.Second // Establishing the TCP connection
+	sessionTimeout = 5 * time.Minute  // The whole SMTP session, so a stalled server can't block delivery forever
+)
+
+type (
+	// Message is an email ready to be delivered: the envelope and the raw RFC 5322 data.
+	Message struct {
+		From string   // Envelope sender
+		To   []string // Envelope recipients, including Cc and Bcc recipients
+		Data []byte   // Headers and body of the message
+	}
+
+	// Mailer delivers messages through a mail transport.
+	Mailer interface {
+		Send(msg Message) error
+	}
+
+	// SMTPMailer delivers messages through an SMTP server.
+	SMTPMailer struct {
+		Host      string      // SMTP server address
+		Port      string      // SMTP server port
+		Username  string      // Username for PLAIN authentication, empty to skip authentication
+		Password  string      // Password for PLAIN authentication
+		Security  string      // SecurityStartTLS, SecurityTLS or SecurityNone
+		TLSConfig *tls.Config // Optional TLS settings, the server name is set to Host when nil
+	}
+
+	// FileMailer writes every message to a directory, as plain .eml files or as a Maildir.
+	FileMailer struct {
+		Dir     string // Directory the messages are written to
+		Maildir bool   // Deliver to the new/ folder of a Maildir instead of writing .eml files
+	}
+
+	// MemoryMailer records the messages sent through it.
+	MemoryMailer struct {
+		mu       sync.Mutex // Guards messages
+		messages []Message  // Messages sent so far
+	}
+)
+
+// NewMailer returns the Mailer selected by MAIL_TRANSPORT, smtp by default.
+func NewMailer() (Mailer, error) {
+	transport := strings.ToLower(os.Getenv("MAIL_TRANSPORT"))
+	switch transport {
+	case "", TransportSMTP:
+		return newSMTPMailer()
+	case TransportFile, TransportMaildir:
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("missing MAIL_DIR for the %s mail transport", transport)
+		}
+		return &FileMailer{Dir: dir, Maildir: transport == TransportMaildir}, nil
+	case TransportMemory:
+		return &MemoryMailer{}, nil
+	}
+	return nil, fmt.Errorf("unknown mail transport: %s", transport)
+}
+
+// newSMTPMailer creates an SMTPMailer from the SMTP_* environment variables.
+func newSMTPMailer() (*SMTPMailer, error) {
+	m := &SMTPMailer{
+		Host:     os.Getenv("SMTP_SERVER"), // SMTP server address
+		Port:     os.Getenv("SMTP_PORT"),   // SMTP server port
+		Username: os.Getenv("SMTP_SENDER"), // Sender's email address
+		Password: os.Getenv("SMTP_PASSWD"), // Sender's email password
+		Security: strings.ToLower(os.Getenv("SMTP_SECURITY")),
+	}
+	if m.Security == "" {
+		m.Security = SecurityStartTLS
+	}
+
+	// Validate environment variables for SMTP configuration
+	if m.Host == "" || m.Port == "" {
+		return nil, fmt.Errorf("missing SMTP configuration in environment variables")
+	}
+	if m.Security != SecurityStartTLS && m.Security != SecurityTLS && m.Security != SecurityNone {
+		return nil, fmt.Errorf("invalid SMTP security mode: %s", m.Security)
+	}
+	return m, nil
+}
+
+// Send delivers the message, refusing to authenticate over a connection that isn't encrypted.
+func (m *SMTPMailer) Send(msg Message) error {
+	client, err := m.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	if m.Username != "" {
+		// PlainAuth only sends credentials over TLS or to localhost
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate with the SMTP server: %w", err)
+		}
+	}
+
+	if err := client.Mail(msg.From); err != nil {
+		return fmt.Errorf("failed to set the sender: %w", err)
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("failed to add recipient %s: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start the message: %w", err)
+	}
+	if _, err := w.Write(msg.Data); err != nil {
+		return fmt.Errorf("failed to write the message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return client.Quit()
+}
+
+// dial connects to the SMTP server, negotiating TLS according to the security mode.
+func (m *SMTPMailer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.Host, m.Port)
+	tlsConfig := m.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: m.Host}
+	}
+
+	if m.Security == SecurityTLS {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.SetDeadline(time.Now().Add(sessionTimeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		client, err := smtp.NewClient(conn, m.Host)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return client, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// The deadline also covers the TLS connection STARTTLS wraps around conn
+	if err := conn.SetDeadline(time.Now().Add(sessionTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if m.Security == SecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("the SMTP server doesn't support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Send writes the message to a new file. Maildir messages are written to tmp/ and moved into
+// new/ once complete, so mail readers never see a partial message.
+func (m *FileMailer) Send(msg Message) error {
+	name, err := uniqueName()
+	if err != nil {
+		return err
+	}
+
+	if !m.Maildir {
+		if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create mail directory: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(m.Dir, name+".eml"), msg.Data, 0o644); err != nil {
+			return fmt.Errorf("failed to write email: %w", err)
+		}
+		return nil
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, sub), 0o755); err != nil {
+			return fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+	tmpPath := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, msg.Data, 0o644); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(m.Dir, "new", name)); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to deliver email: %w", err)
+	}
+	return nil
+}
+
+// Send records the message.
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// uniqueName returns a file name unique across processes and hosts, following the Maildir convention.
+func uniqueName() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate file name: %w", err)
+	}
+	host, _ := os.Hostname()
+	host = strings.NewReplacer("/", "_", ":", "_").Replace(host)
+	return fmt.Sprintf("%d.%d_%s.%s", time.Now().UnixNano(), os.Getpid(), hex.EncodeToString(random), host), nil
+}
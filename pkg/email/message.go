@@ -0,0 +1,200 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+type (
+	// Mail is an email before it is encoded: its addresses, subject, alternative bodies and attachments.
+	Mail struct {
+		From        mail.Address   // Author of the message
+		To          []mail.Address // Primary recipients, shown in the To header
+		Cc          []mail.Address // Copied recipients, shown in the Cc header
+		Bcc         []mail.Address // Blind copied recipients, only added to the envelope
+		Subject     string         // Subject, encoded per RFC 2047 when it isn't plain ASCII
+		Text        string         // text/plain rendering of the message
+		HTML        string         // text/html rendering of the message
+		Attachments []Attachment   // Files attached to the message
+	}
+
+	// Attachment is a file attached to a Mail.
+	Attachment struct {
+		Filename    string // Name offered to the recipient
+		ContentType string // MIME type of Data
+		Data        []byte // Content of the file
+	}
+)
+
+// Message encodes the mail as a MIME message sent at date, along with its envelope. The bodies
+// are a multipart/alternative, wrapped in a multipart/mixed when there are attachments. Bcc
+// recipients are only part of the envelope.
+func (m Mail) Message(date time.Time) (Message, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	messageId, err := newMessageId(m.From.Address)
+	if err != nil {
+		return Message{}, err
+	}
+
+	contentType := "multipart/alternative"
+	if len(m.Attachments) > 0 {
+		contentType = "multipart/mixed"
+	}
+
+	// Headers of the message, in the usual order
+	header := []struct{ name, value string }{
+		{"From", m.From.String()},
+		{"To", joinAddresses(m.To)},
+		{"Cc", joinAddresses(m.Cc)},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", messageId},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", mime.FormatMediaType(contentType, map[string]string{"boundary": body.Boundary()})},
+	}
+	var data bytes.Buffer
+	for _, h := range header {
+		if h.value != "" {
+			fmt.Fprintf(&data, "%s: %s\r\n", h.name, h.value)
+		}
+	}
+	data.WriteString("\r\n")
+
+	if len(m.Attachments) == 0 {
+		if err := m.writeAlternatives(body); err != nil {
+			return Message{}, err
+		}
+	} else {
+		// The bodies are nested as the first part of the mixed message, followed by the files
+		var alternatives bytes.Buffer
+		nested := multipart.NewWriter(&alternatives)
+		if err := m.writeAlternatives(nested); err != nil {
+			return Message{}, err
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": nested.Boundary()}))
+		part, err := body.CreatePart(header)
+		if err != nil {
+			return Message{}, fmt.Errorf("failed to create the body part: %w", err)
+		}
+		if _, err := part.Write(alternatives.Bytes()); err != nil {
+			return Message{}, fmt.Errorf("failed to write the body part: %w", err)
+		}
+
+		for _, a := range m.Attachments {
+			if err := writeAttachment(body, a); err != nil {
+				return Message{}, err
+			}
+		}
+	}
+	if err := body.Close(); err != nil {
+		return Message{}, fmt.Errorf("failed to encode email: %w", err)
+	}
+	data.Write(buf.Bytes())
+
+	return Message{From: m.From.Address, To: m.Recipients(), Data: data.Bytes()}, nil
+}
+
+// writeAlternatives writes the text and HTML bodies as the parts of a multipart/alternative and closes it.
+func (m Mail) writeAlternatives(w *multipart.Writer) error {
+	// Clients show the last alternative they support, so the HTML body goes last
+	if err := writeTextPart(w, "text/plain", m.Text); err != nil {
+		return err
+	}
+	if err := writeTextPart(w, "text/html", m.HTML); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encode email: %w", err)
+	}
+	return nil
+}
+
+// Recipients returns the envelope recipients: every To, Cc and Bcc address.
+func (m Mail) Recipients() []string {
+	var recipients []string
+	for _, list := range [][]mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, a := range list {
+			recipients = append(recipients, a.Address)
+		}
+	}
+	return recipients
+}
+
+// writeTextPart adds a quoted-printable UTF-8 text part of the given media type.
+func writeTextPart(w *multipart.Writer, mediaType, content string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": "utf-8"}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("failed to create %s part: %w", mediaType, err)
+	}
+
+	// The quoted-printable writer also turns line breaks into CRLF
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return fmt.Errorf("failed to write %s part: %w", mediaType, err)
+	}
+	return qp.Close()
+}
+
+// writeAttachment adds a base64 encoded file, wrapping the encoded data at 76 characters per line.
+func writeAttachment(w *multipart.Writer, a Attachment) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", a.ContentType)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	header.Set("Content-Transfer-Encoding", "base64")
+
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("failed to attach %s: %w", a.Filename, err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	for len(encoded) > 0 {
+		n := min(len(encoded), 76)
+		if _, err := io.WriteString(part, encoded[:n]+"\r\n"); err != nil {
+			return fmt.Errorf("failed to attach %s: %w", a.Filename, err)
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// joinAddresses formats a list of addresses for an address header, encoding display names per RFC 2047.
+func joinAddresses(addresses []mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, a := range addresses {
+		formatted[i] = a.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// newMessageId returns a unique Message-ID in the domain of the sender.
+func newMessageId(from string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate Message-ID: %w", err)
+	}
+
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain), nil
+}
@@ -0,0 +1,149 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"stori_challenge/pkg/i18n"
+	"stori_challenge/web"
+	"sync"
+	textTemplate "text/template"
+	"time"
+)
+
+type (
+	// executor is a parsed html/template or text/template template.
+	executor interface {
+		Execute(w io.Writer, data any) error
+	}
+
+	// cachedTemplate is a parsed template along with the version of the file it was parsed from.
+	cachedTemplate struct {
+		tmpl    executor  // Parsed template
+		modTime time.Time // Modification time of the file, zero for embedded templates
+		size    int64     // Size of the file, zero for embedded templates
+	}
+)
+
+var (
+	templatesMu sync.Mutex                    // Guards templates
+	templates   = map[string]cachedTemplate{} // Parsed templates by source, path and locale
+)
+
+// render executes the template name.extension translated to the locale with data. Templates are
+// read from EMAIL_TEMPLATE_DIR when it holds them, falling back to the templates embedded in the
+// binary. Parsed templates are cached, and templates from EMAIL_TEMPLATE_DIR are parsed again
+// when their file changes, so they can be edited without restarting the service.
+func render(name, extension, locale string, data any) (string, error) {
+	t, err := loadTemplate(name, extension, locale)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute email template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// loadTemplate returns the parsed template translated to the locale, such as email_template.es.html,
+// or the English template when there is no translation. Each file is looked up in EMAIL_TEMPLATE_DIR
+// first, so an override of the English template doesn't replace an embedded translation.
+func loadTemplate(name, extension, locale string) (executor, error) {
+	candidates := []string{name + "." + extension}
+	if locale != i18n.English {
+		candidates = append([]string{name + "." + locale + "." + extension}, candidates...)
+	}
+
+	dir := os.Getenv("EMAIL_TEMPLATE_DIR")
+	for _, file := range candidates {
+		// Templates of the override directory take precedence
+		if dir != "" {
+			filePath := filepath.Join(dir, file)
+			info, err := os.Stat(filePath)
+			if err == nil {
+				return cachedFile(filePath, info, extension, locale)
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("failed to read email template: %w", err)
+			}
+		}
+
+		embedded := path.Join("template", file)
+		if _, err := fs.Stat(web.Templates, embedded); err == nil {
+			return cachedEmbedded(embedded, extension, locale)
+		}
+	}
+	return nil, fmt.Errorf("email template %s.%s not found", name, extension)
+}
+
+// cachedFile returns the template parsed from a file, parsing it again when it changed since it was cached.
+func cachedFile(filePath string, info fs.FileInfo, extension, locale string) (executor, error) {
+	key := "file:" + filePath + ":" + locale
+
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+
+	if cached, ok := templates[key]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.tmpl, nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email template: %w", err)
+	}
+	t, err := parseTemplate(filepath.Base(filePath), extension, locale, content)
+	if err != nil {
+		return nil, err
+	}
+	templates[key] = cachedTemplate{tmpl: t, modTime: info.ModTime(), size: info.Size()}
+	return t, nil
+}
+
+// cachedEmbedded returns the template parsed from a file embedded in the binary, parsing it only once.
+func cachedEmbedded(embedded, extension, locale string) (executor, error) {
+	key := "embed:" + embedded + ":" + locale
+
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+
+	if cached, ok := templates[key]; ok {
+		return cached.tmpl, nil
+	}
+
+	content, err := web.Templates.ReadFile(embedded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email template: %w", err)
+	}
+	t, err := parseTemplate(path.Base(embedded), extension, locale, content)
+	if err != nil {
+		return nil, err
+	}
+	templates[key] = cachedTemplate{tmpl: t}
+	return t, nil
+}
+
+// parseTemplate parses a template with the formatting functions of the locale, as HTML for the
+// html extension so the data is escaped, and as plain text otherwise.
+func parseTemplate(name, extension, locale string, content []byte) (executor, error) {
+	var (
+		t   executor
+		err error
+	)
+	if extension == "html" {
+		t, err = htmlTemplate.New(name).Funcs(i18n.Funcs(locale)).Parse(string(content))
+	} else {
+		t, err = textTemplate.New(name).Funcs(i18n.Funcs(locale)).Parse(string(content))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email template: %w", err)
+	}
+	return t, nil
+}
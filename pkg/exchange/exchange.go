@@ -0,0 +1,123 @@
+package exchange
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"stori_challenge/pkg/money"
+	"strings"
+	"sync"
+)
+
+// ErrRateNotFound is returned when no exchange rate is known between two currencies.
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+type (
+	// RateProvider supplies exact exchange rates between currencies.
+	RateProvider interface {
+		Rate(from, to string) (*big.Rat, error) // Units of `to` for one unit of `from`
+	}
+
+	// Pair identifies a conversion from one currency into another.
+	Pair struct {
+		From, To string // ISO 4217 currency codes
+	}
+
+	// Table is a RateProvider backed by a fixed set of rates, usually loaded from a local file.
+	// Inverse rates are derived automatically.
+	Table map[Pair]*big.Rat
+)
+
+var (
+	defaultProvider RateProvider
+	defaultErr      error
+	once            sync.Once
+)
+
+// DefaultProvider returns the singleton RateProvider loaded from the EXCHANGE_RATES_FILE CSV.
+// It fails when the file is not configured or can't be read, so that amounts in other
+// currencies are never left without a rate; main loads it on startup.
+func DefaultProvider() (RateProvider, error) {
+	once.Do(func() {
+		path := os.Getenv("EXCHANGE_RATES_FILE")
+		if path == "" {
+			defaultErr = errors.New("EXCHANGE_RATES_FILE is not set")
+			return
+		}
+
+		table, err := LoadTable(path)
+		if err != nil {
+			defaultErr = fmt.Errorf("error loading exchange rates: %w", err)
+			return
+		}
+		defaultProvider = table
+	})
+	return defaultProvider, defaultErr
+}
+
+// LoadTable reads exchange rates from a CSV file with the header From,To,Rate, where Rate is
+// an exact decimal such as 17.1234.
+func LoadTable(path string) (Table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open exchange rates file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exchange rates file: %w", err)
+	}
+	if len(records) == 0 || len(records[0]) != 3 || !strings.EqualFold(strings.Join(records[0], ","), "From,To,Rate") {
+		return nil, fmt.Errorf("exchange rates file must start with the header From,To,Rate")
+	}
+
+	table := Table{}
+	for i, record := range records[1:] {
+		from, errFrom := money.ParseCurrency(record[0])
+		to, errTo := money.ParseCurrency(record[1])
+		if errFrom != nil || errTo != nil {
+			return nil, fmt.Errorf("invalid currency on line %d of exchange rates file", i+2)
+		}
+
+		rate, ok := new(big.Rat).SetString(strings.TrimSpace(record[2]))
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate %q on line %d of exchange rates file", record[2], i+2)
+		}
+		table[Pair{From: from, To: to}] = rate
+	}
+	return table, nil
+}
+
+// Rate implements RateProvider, using the inverse rate when only the opposite pair is known.
+func (t Table) Rate(from, to string) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	if rate, ok := t[Pair{From: from, To: to}]; ok {
+		return rate, nil
+	}
+	if rate, ok := t[Pair{From: to, To: from}]; ok {
+		return new(big.Rat).Inv(rate), nil
+	}
+	return nil, fmt.Errorf("%w: %s to %s", ErrRateNotFound, from, to)
+}
+
+// Convert converts amount from one currency into another, rounding to the cent.
+func Convert(provider RateProvider, amount money.Amount, from, to string) (money.Amount, error) {
+	if from == to {
+		return amount, nil
+	}
+
+	rate, err := provider.Rate(from, to)
+	if err != nil {
+		return 0, err
+	}
+	return amount.MulRat(rate), nil
+}
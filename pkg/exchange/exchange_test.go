@@ -0,0 +1,62 @@
+package exchange
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"stori_challenge/pkg/money"
+	"testing"
+)
+
+// convertPair defines a structure for holding conversion test cases.
+type convertPair struct {
+	amount   money.Amount // Amount to convert
+	from, to string       // Currencies of the conversion
+	expected money.Amount // Expected converted amount
+	hasErr   bool         // Indicates if an error is expected for this test case
+}
+
+// List of test cases using a rate of 20 MXN per USD
+var convertTests = []convertPair{
+	{1000, "USD", "MXN", 20000, false},
+	{20000, "MXN", "USD", 1000, false}, // Inverse rate
+	{-1030, "USD", "USD", -1030, false},
+	{1000, "EUR", "USD", 0, true},
+}
+
+// TestConvert tests the Convert function with direct, inverse and missing rates
+func TestConvert(t *testing.T) {
+	table := Table{{From: "USD", To: "MXN"}: big.NewRat(20, 1)}
+
+	for _, pair := range convertTests {
+		converted, err := Convert(table, pair.amount, pair.from, pair.to)
+
+		// Check if the result matches the expected outcome
+		if (err != nil) != pair.hasErr || converted != pair.expected {
+			t.Errorf("For %s %s->%s expected (%s, error: %v), got (%s, %v)", pair.amount, pair.from, pair.to, pair.expected, pair.hasErr, converted, err)
+		}
+		if err != nil && !errors.Is(err, ErrRateNotFound) {
+			t.Errorf("expected ErrRateNotFound, got %v", err)
+		}
+	}
+}
+
+// TestLoadTable tests reading rates from a CSV file
+func TestLoadTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.csv")
+	content := "# comment\nFrom,To,Rate\nusd,MXN,17.1234\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := LoadTable(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rate, err := table.Rate("USD", "MXN")
+	if err != nil || rate.FloatString(4) != "17.1234" {
+		t.Errorf("unexpected rate %v (%v)", rate, err)
+	}
+}
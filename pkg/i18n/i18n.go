@@ -0,0 +1,203 @@
+package i18n
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"stori_challenge/pkg/money"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported locales.
+const (
+	English = "en" // English, the default locale
+	Spanish = "es" // Spanish
+)
+
+// ErrUnsupportedLocale is returned when a locale isn't one of the supported locales.
+var ErrUnsupportedLocale = errors.New("unsupported locale")
+
+// format holds the number formatting conventions of a locale.
+type format struct {
+	decimal   string // Separator between units and cents
+	thousands string // Separator between groups of three digits
+}
+
+var (
+	// formats holds the number formatting conventions of each supported locale.
+	formats = map[string]format{
+		English: {decimal: ".", thousands: ","},
+		Spanish: {decimal: ",", thousands: "."},
+	}
+
+	// monthNames holds the names of the months in each supported locale, January first.
+	monthNames = map[string][12]string{
+		English: {"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		Spanish: {"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+	}
+
+	// messages holds the texts written outside the translated templates, such as the email subject
+	// and the labels of the PDF statement, as fmt formats in each supported locale.
+	messages = map[string]map[string]string{
+		English: {
+			"subject":                  "Your account summary",
+			"statement.title":          "Account statement",
+			"statement.account":        "Account:",
+			"statement.period":         "Period:",
+			"statement.period_range":   "%s to %s",
+			"statement.total_balance":  "Total balance:",
+			"statement.average_debit":  "Average debit:",
+			"statement.average_credit": "Average credit:",
+			"statement.balance_in":     "Balance in %s:",
+			"statement.date":           "Date",
+			"statement.id":             "Id",
+			"statement.amount":         "Amount",
+			"statement.currency":       "Currency",
+			"statement.category":       "Category",
+			"statement.description":    "Description",
+			"statement.empty":          "No transactions.",
+			"statement.truncated":      "Only the %d most recent transactions are listed.",
+		},
+		Spanish: {
+			"subject":                  "Resumen de tu cuenta",
+			"statement.title":          "Estado de cuenta",
+			"statement.account":        "Cuenta:",
+			"statement.period":         "Periodo:",
+			"statement.period_range":   "%s a %s",
+			"statement.total_balance":  "Saldo total:",
+			"statement.average_debit":  "Promedio de débitos:",
+			"statement.average_credit": "Promedio de créditos:",
+			"statement.balance_in":     "Saldo en %s:",
+			"statement.date":           "Fecha",
+			"statement.id":             "Id",
+			"statement.amount":         "Importe",
+			"statement.currency":       "Moneda",
+			"statement.category":       "Categoría",
+			"statement.description":    "Descripción",
+			"statement.empty":          "Sin transacciones.",
+			"statement.truncated":      "Solo se listan las %d transacciones más recientes.",
+		},
+	}
+)
+
+// Parse validates a locale such as "es" or "es-MX", keeping only its language. An empty locale
+// falls back to DEFAULT_LOCALE or English.
+func Parse(locale string) (string, error) {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if locale == "" {
+		locale = strings.ToLower(os.Getenv("DEFAULT_LOCALE"))
+	}
+	if locale == "" {
+		return English, nil
+	}
+
+	// Regional variants share the conventions of their language
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	if _, ok := formats[locale]; !ok {
+		return "", ErrUnsupportedLocale
+	}
+	return locale, nil
+}
+
+// MonthName returns the name of a month in the given locale.
+func MonthName(locale string, month time.Month) string {
+	names, ok := monthNames[locale]
+	if !ok {
+		names = monthNames[English]
+	}
+	if month < time.January || month > time.December {
+		return strconv.Itoa(int(month))
+	}
+	return names[month-1]
+}
+
+// Text returns the message key of the catalog in the given locale, formatted with args. Locales
+// without the message fall back to English, and unknown keys are returned as they are.
+func Text(locale, key string, args ...any) string {
+	format, ok := messages[locale][key]
+	if !ok {
+		format, ok = messages[English][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// FormatNumber formats an integer with the thousands separator of the locale, e.g. 1,234 or 1.234.
+func FormatNumber(locale string, n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	return sign + group(digits, lookup(locale).thousands)
+}
+
+// FormatAmount formats an amount with the separators of the locale, e.g. -1,234.50 or -1.234,50.
+func FormatAmount(locale string, amount money.Amount) string {
+	f := lookup(locale)
+
+	// money.Amount.String gives the exact digits, such as -1234.50
+	units, cents, _ := strings.Cut(amount.String(), ".")
+	sign := ""
+	if strings.HasPrefix(units, "-") {
+		sign, units = "-", units[1:]
+	}
+	return sign + group(units, f.thousands) + f.decimal + cents
+}
+
+// FormatMoney formats an amount followed by its currency code, e.g. 1,234.50 USD or 1.234,50 USD.
+func FormatMoney(locale string, amount money.Amount, currency string) string {
+	if currency == "" {
+		return FormatAmount(locale, amount)
+	}
+	return FormatAmount(locale, amount) + " " + currency
+}
+
+// Funcs returns the template functions that format values in the given locale:
+// money (amount and currency), amount, number, month and text (message key and arguments).
+func Funcs(locale string) map[string]any {
+	return map[string]any{
+		"money":  func(amount money.Amount, currency string) string { return FormatMoney(locale, amount, currency) },
+		"amount": func(amount money.Amount) string { return FormatAmount(locale, amount) },
+		"number": func(n int64) string { return FormatNumber(locale, n) },
+		"month":  func(month time.Month) string { return MonthName(locale, month) },
+		"text":   func(key string, args ...any) string { return Text(locale, key, args...) },
+	}
+}
+
+// lookup returns the number formatting conventions of a locale, English for unknown locales.
+func lookup(locale string) format {
+	if f, ok := formats[locale]; ok {
+		return f
+	}
+	return formats[English]
+}
+
+// group inserts the thousands separator every three digits from the right.
+func group(digits, separator string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(separator)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
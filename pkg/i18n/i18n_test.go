@@ -0,0 +1,81 @@
+package i18n
+
+import (
+	"stori_challenge/pkg/money"
+	"testing"
+	"time"
+)
+
+// amountPair defines a structure for holding amount formatting test cases.
+type amountPair struct {
+	locale   string       // Locale of the formatting
+	amount   money.Amount // Amount in cents
+	expected string       // Expected formatted amount
+}
+
+// List of test cases for both locales
+var amountTests = []amountPair{
+	{English, 3974, "39.74"},
+	{English, -123456789, "-1,234,567.89"},
+	{English, 100000, "1,000.00"},
+	{Spanish, 3974, "39,74"},
+	{Spanish, -123456789, "-1.234.567,89"},
+	{Spanish, -5, "-0,05"},
+	{"fr", 100000, "1,000.00"}, // Unknown locales use English conventions
+}
+
+// TestFormatAmount tests the separators of each locale
+func TestFormatAmount(t *testing.T) {
+	for _, pair := range amountTests {
+		if got := FormatAmount(pair.locale, pair.amount); got != pair.expected {
+			t.Errorf("FormatAmount(%s, %d) = %s, expected %s", pair.locale, pair.amount, got, pair.expected)
+		}
+	}
+
+	if got := FormatMoney(Spanish, 150050, "MXN"); got != "1.500,50 MXN" {
+		t.Errorf("FormatMoney = %s", got)
+	}
+	if got := FormatNumber(English, 1234567); got != "1,234,567" {
+		t.Errorf("FormatNumber = %s", got)
+	}
+}
+
+// TestMonthName tests the localized month names
+func TestMonthName(t *testing.T) {
+	if got := MonthName(Spanish, time.August); got != "agosto" {
+		t.Errorf("MonthName(es, August) = %s", got)
+	}
+	if got := MonthName(English, time.August); got != "August" {
+		t.Errorf("MonthName(en, August) = %s", got)
+	}
+}
+
+// TestText tests the messages of the catalog and their fallbacks
+func TestText(t *testing.T) {
+	if got := Text(Spanish, "statement.balance_in", "USD"); got != "Saldo en USD:" {
+		t.Errorf("Text(es, statement.balance_in) = %s", got)
+	}
+	if got := Text("fr", "statement.title"); got != "Account statement" {
+		t.Errorf("Text(fr, statement.title) = %s", got)
+	}
+	if got := Text(English, "missing"); got != "missing" {
+		t.Errorf("Text(en, missing) = %s", got)
+	}
+}
+
+// TestParse tests the validation of locales and the default locale
+func TestParse(t *testing.T) {
+	for input, expected := range map[string]string{"es": Spanish, "es-MX": Spanish, " EN_us ": English, "": English} {
+		if got, err := Parse(input); err != nil || got != expected {
+			t.Errorf("Parse(%q) = %s, %v, expected %s", input, got, err, expected)
+		}
+	}
+	if _, err := Parse("fr"); err != ErrUnsupportedLocale {
+		t.Errorf("Parse(fr): expected %v, got %v", ErrUnsupportedLocale, err)
+	}
+
+	t.Setenv("DEFAULT_LOCALE", "es")
+	if got, _ := Parse(""); got != Spanish {
+		t.Errorf("Parse with DEFAULT_LOCALE=es = %s", got)
+	}
+}
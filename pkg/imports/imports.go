@@ -0,0 +1,146 @@
+package imports
+
+import (
+	"errors"
+	"fmt"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrAlreadyReverted is returned when reverting a batch whose rows were already removed.
+	ErrAlreadyReverted = errors.New("import batch already reverted")
+
+	// ErrStillProcessing is returned when reverting a batch whose import hasn't finished.
+	ErrStillProcessing = errors.New("import batch still processing")
+
+	// ErrOverwrittenLater is returned when reverting a batch whose rows a later import overwrote,
+	// since reverting it would remove or replace the values of that import.
+	ErrOverwrittenLater = errors.New("import batch overwritten by a later import")
+)
+
+// StartBatch creates a new ImportBatch in the processing state.
+func StartBatch(batch *models.ImportBatch) error {
+	batch.Status = models.ImportStatusProcessing
+	batch.StartedAt = time.Now()
+
+	if err := config.GetDB().Create(batch).Error; err != nil {
+		return fmt.Errorf("failed to create import batch: %w", err)
+	}
+	return nil
+}
+
+// FinishBatch stores the final row counts and status of an ImportBatch.
+func FinishBatch(batch *models.ImportBatch, status string) error {
+	now := time.Now()
+	batch.Status = status
+	batch.FinishedAt = &now
+
+	if err := config.GetDB().Save(batch).Error; err != nil {
+		return fmt.Errorf("failed to update import batch %d: %w", batch.Id, err)
+	}
+	return nil
+}
+
+// GetBatch retrieves an ImportBatch by its primary key.
+func GetBatch(id uint) (models.ImportBatch, error) {
+	var batch models.ImportBatch
+	if err := config.GetDB().First(&batch, id).Error; err != nil {
+		return models.ImportBatch{}, fmt.Errorf("failed to get import batch %d: %w", id, err)
+	}
+	return batch, nil
+}
+
+// restoreBatchSize is the number of overwritten rows restored at a time by RevertBatch.
+const restoreBatchSize = 1000
+
+// RevertBatch removes every ledger row inserted by the batch, restores the rows it overwrote to
+// their previous values and marks it as reverted. It returns the number of rows deleted. Batches
+// still being imported can't be reverted, nor batches whose rows a later import overwrote until
+// that import is reverted.
+func RevertBatch(id uint) (int64, error) {
+	var deleted int64
+
+	err := config.GetDB().Transaction(func(tx *gorm.DB) error {
+		// Lock the batch so a concurrent revert waits for this one
+		var batch models.ImportBatch
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&batch, id).Error; err != nil {
+			return fmt.Errorf("failed to get import batch %d: %w", id, err)
+		}
+		switch batch.Status {
+		case models.ImportStatusReverted:
+			return ErrAlreadyReverted
+		case models.ImportStatusProcessing:
+			return ErrStillProcessing
+		}
+
+		// The rows of the batch must still hold its values
+		reverted := tx.Model(&models.ImportBatch{}).Select("id").Where("status = ?", models.ImportStatusReverted)
+		rows := tx.Model(&models.SQLDocument{}).Select("id").Scopes(Rows(id))
+		var later int64
+		err := tx.Model(&models.OverwrittenRow{}).
+			Where("import_batch_id > ? AND import_batch_id NOT IN (?) AND sql_document_id IN (?)", id, reverted, rows).
+			Count(&later).Error
+		if err != nil {
+			return fmt.Errorf("failed to check the later imports of import batch %d: %w", id, err)
+		}
+		if later > 0 {
+			return ErrOverwrittenLater
+		}
+
+		// Put back the values of the rows this upload overwrote
+		var previous []models.OverwrittenRow
+		result := tx.Where("import_batch_id = ?", id).
+			FindInBatches(&previous, restoreBatchSize, func(_ *gorm.DB, _ int) error {
+				for _, p := range previous {
+					err := tx.Model(&models.SQLDocument{}).Where("id = ?", p.SQLDocumentId).Updates(map[string]any{
+						"date":         p.Date,
+						"amount_cents": p.Transaction,
+						"currency":     p.Currency,
+						"description":  p.Description,
+						"category":     p.Category,
+						"anomalies":    p.Anomalies,
+					}).Error
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to restore rows overwritten by import batch %d: %w", id, result.Error)
+		}
+
+		// Delete the rows that came from this upload
+		result = tx.Where("import_batch_id = ?", id).Delete(&models.SQLDocument{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete rows of import batch %d: %w", id, result.Error)
+		}
+		deleted = result.RowsAffected
+
+		if err := tx.Model(&batch).Update("status", models.ImportStatusReverted).Error; err != nil {
+			return fmt.Errorf("failed to mark import batch %d as reverted: %w", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// Rows limits a query on the ledger to the rows stored by an import: the rows it inserted, which
+// carry its ID, and the rows it overwrote, which keep the ID of the import that inserted them.
+func Rows(importBatchId uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		overwritten := db.Session(&gorm.Session{NewDB: true}).
+			Model(&models.OverwrittenRow{}).
+			Select("sql_document_id").
+			Where("import_batch_id = ?", importBatchId)
+		return db.Where("(import_batch_id = ? OR id IN (?))", importBatchId, overwritten)
+	}
+}
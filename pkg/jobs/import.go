@@ -0,0 +1,180 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"stori_challenge/pkg/anomaly"
+	"stori_challenge/pkg/budget"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/csv"
+	"stori_challenge/pkg/exchange"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/outbox"
+	"stori_challenge/pkg/recurring"
+	"stori_challenge/pkg/summary"
+	"time"
+)
+
+// Defaults of the import pool, overridable with IMPORT_WORKERS and IMPORT_QUEUE_SIZE.
+const (
+	defaultWorkers   = 4   // Files imported at the same time
+	defaultQueueSize = 100 // Jobs waiting for a worker before uploads are refused
+
+	defaultStatementRows = 10000 // Transactions listed in attached statements, overridable with STATEMENT_MAX_ROWS
+)
+
+type (
+	// ImportParams are the parameters of an import job, stored with the job so it survives restarts.
+	ImportParams struct {
+		FilePath    string            // Spooled copy of the uploaded file, removed once the job finishes
+		Email       string            // Recipient of the summary email
+		Options     csv.ImportOptions // How the file is imported
+		Attachments []string          // Statement formats attached to the summary email
+		Locale      string            // Locale of the summary email, empty for the default
+		Period      models.Period     // Period summarized in the email, resolved when the file was uploaded
+	}
+
+	// ImportResult is the outcome of an import job.
+	ImportResult struct {
+		Message  string               `json:"message"`          // Human readable outcome
+		ImportId uint                 `json:"importId"`         // Import batch created for the file
+		Report   *csv.ImportReport    `json:"report"`           // Per-row import report
+		EmailId  uint                 `json:"emailId"`          // Outbox email carrying the summary, 0 when none was queued
+		Alerts   []models.BudgetAlert `json:"alerts,omitempty"` // Budgets the import pushed over their limit
+	}
+)
+
+// NewImportPool creates the Pool that runs import jobs, sized by IMPORT_WORKERS and IMPORT_QUEUE_SIZE.
+// While it runs, the jobs whose claim is older than IMPORT_JOB_LEASE are failed, so a job left
+// running by a crashed worker doesn't stay running forever.
+func NewImportPool() (*Pool, error) {
+	workers, err := config.GetEnvInt("IMPORT_WORKERS", defaultWorkers)
+	if err != nil {
+		return nil, err
+	}
+	queueSize, err := config.GetEnvInt("IMPORT_QUEUE_SIZE", defaultQueueSize)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := config.GetEnvDuration("IMPORT_JOB_LEASE", defaultLease)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := NewPool(workers, queueSize, func(id uint) { Process(id, RunImport) })
+	pool.Every(min(lease, maxLeaseCheckInterval), func() {
+		if n, err := FailExpired(lease); err != nil {
+			log.Println("Error:", err)
+		} else if n > 0 {
+			log.Printf("%d interrupted jobs marked as failed", n)
+		}
+	})
+	return pool, nil
+}
+
+// SpoolFile creates the file an upload is copied into until its job runs, in IMPORT_SPOOL_DIR
+// or in a directory under the system's temporary directory.
+func SpoolFile() (*os.File, error) {
+	dir := os.Getenv("IMPORT_SPOOL_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "stori-imports")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return os.CreateTemp(dir, "import-*.csv")
+}
+
+// RunImport imports the spooled file of a job and queues the account summary email for the uploader.
+func RunImport(job *models.ImportJob, progress func(rows int)) (any, error) {
+	var params ImportParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil {
+		return nil, fmt.Errorf("invalid job parameters: %w", err)
+	}
+	defer removeUpload(job)
+
+	rates, err := exchange.DefaultProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	// Process the spooled CSV file, recording it as an import batch
+	opts := params.Options
+	opts.Progress = progress
+	report, err := csv.ProcessCSVFile(params.FilePath, opts)
+	if err != nil {
+		if report.ImportId == 0 {
+			return nil, err // Rejected before any row was read
+		}
+		return ImportResult{Message: "The CSV file could not be imported", ImportId: report.ImportId, Report: &report}, err
+	}
+	result := ImportResult{Message: "CSV file processed and summary queued for delivery", ImportId: report.ImportId, Report: &report}
+	if len(report.Errors) > 0 {
+		result.Message = "CSV file processed with skipped rows and summary queued for delivery"
+	}
+
+	// Create the summary from the account's ledger
+	provider := summary.NewFinanceService(opts.AccountId)
+	emailData, err := summary.CreateSummary(provider, rates, opts.Currency, params.Period)
+	if err != nil {
+		result.Message = "CSV file processed but the summary could not be created"
+		return result, fmt.Errorf("error creating the summary: %w", err)
+	}
+	emailData.EmailTo = params.Email // Set the recipient email address
+	emailData.Locale = params.Locale // Write the email in the recipient's language
+
+	// Warn about the budgets the new transactions pushed over their limit. The rows are already
+	// stored, so a failed check only leaves the alerts out of the summary
+	if alerts, err := budget.Check(opts.AccountId, report.ImportId, opts.Currency, rates); err != nil {
+		log.Printf("Error checking budgets of import %d: %v", report.ImportId, err)
+	} else {
+		emailData.Alerts = alerts
+		result.Alerts = alerts
+	}
+
+	// Highlight the new transactions that look like fraud or data-entry errors, or send the summary without them
+	if emailData.Anomalies, err = anomaly.ForBatch(opts.AccountId, report.ImportId); err != nil {
+		log.Printf("Error listing flagged transactions of import %d: %v", report.ImportId, err)
+	}
+
+	// List the subscriptions and other debits expected to repeat, or send the summary without them
+	if emailData.Recurring, err = recurring.ForAccount(opts.AccountId, time.Now()); err != nil {
+		log.Printf("Error detecting recurring transactions of account %d: %v", opts.AccountId, err)
+	}
+
+	// List the account's transactions when statements are attached to the summary
+	if len(params.Attachments) > 0 {
+		limit, err := config.GetEnvInt("STATEMENT_MAX_ROWS", defaultStatementRows)
+		if err != nil {
+			return result, err
+		}
+		if err := summary.AddStatement(&emailData, provider, limit); err != nil {
+			result.Message = "CSV file processed but the statement could not be created"
+			return result, err
+		}
+		emailData.Attachments = params.Attachments
+	}
+
+	// Queue the summary email; the outbox dispatcher delivers it, retrying if the mail server fails
+	queued, err := outbox.Enqueue(report.ImportId, emailData)
+	if err != nil {
+		result.Message = "CSV file processed but the summary email could not be queued"
+		return result, err
+	}
+	result.EmailId = queued.Id
+	return result, nil
+}
+
+// removeUpload deletes the spooled file of an import job, if it is still there.
+func removeUpload(job *models.ImportJob) {
+	var params ImportParams
+	if err := json.Unmarshal([]byte(job.Params), &params); err != nil || params.FilePath == "" {
+		return
+	}
+	if err := os.Remove(params.FilePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing upload of job %d: %v", job.Id, err)
+	}
+}
@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/models"
+	"time"
+)
+
+// defaultLease is how long a running job may go without progress before its worker is considered
+// gone, overridable with IMPORT_JOB_LEASE.
+const defaultLease = 10 * time.Minute
+
+// maxLeaseCheckInterval is the longest time between two looks for expired leases, see NewImportPool.
+const maxLeaseCheckInterval = time.Minute
+
+// RunFunc processes a job, reporting the rows read so far through progress. The returned result
+// is stored as the job's JSON outcome, also when the job fails.
+type RunFunc func(job *models.ImportJob, progress func(rows int)) (any, error)
+
+// Create stores a new job in the queued state with its JSON encoded parameters.
+func Create(job *models.ImportJob, params any) error {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode job parameters: %w", err)
+	}
+	job.Params = string(encoded)
+	job.State = models.JobStateQueued
+
+	if err := config.GetDB().Create(job).Error; err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves a job by its primary key.
+func Get(id uint) (models.ImportJob, error) {
+	var job models.ImportJob
+	if err := config.GetDB().First(&job, id).Error; err != nil {
+		return models.ImportJob{}, fmt.Errorf("failed to get job %d: %w", id, err)
+	}
+	return job, nil
+}
+
+// Process runs the job identified by id with run, keeping its state, progress and outcome up to date.
+func Process(id uint, run RunFunc) {
+	job, err := Get(id)
+	if err != nil {
+		log.Println("Error:", err)
+		return
+	}
+
+	// Claim the job atomically so it never runs twice, even when it was enqueued twice
+	now := time.Now()
+	claim := config.GetDB().Model(&job).Where("state = ?", models.JobStateQueued).
+		Updates(map[string]any{"state": models.JobStateRunning, "started_at": now, "claimed_at": now})
+	if claim.Error != nil {
+		log.Printf("Error starting job %d: %v", id, claim.Error)
+		return
+	}
+	if claim.RowsAffected == 0 {
+		return // Already picked up by another worker
+	}
+	job.State = models.JobStateRunning
+	job.StartedAt = &now
+	job.ClaimedAt = &now
+
+	// Every progress report also renews the claim, telling Recover the worker is still alive
+	progress := func(rows int) {
+		err := config.GetDB().Model(&models.ImportJob{}).Where("id = ?", id).
+			Updates(map[string]any{"progress": rows, "claimed_at": time.Now()}).Error
+		if err != nil {
+			log.Printf("Error updating progress of job %d: %v", id, err)
+		}
+	}
+
+	result, err := safeRun(run, &job, progress)
+	if err := Finish(&job, result, err); err != nil {
+		log.Println("Error:", err)
+	}
+}
+
+// Finish stores the outcome of a job, marking it as failed when err is not nil.
+func Finish(job *models.ImportJob, result any, err error) error {
+	now := time.Now()
+	job.FinishedAt = &now
+	job.ClaimedAt = nil
+	job.State = models.JobStateSucceeded
+	job.Error = ""
+	if err != nil {
+		job.State = models.JobStateFailed
+		job.Error = err.Error()
+	}
+
+	if result != nil {
+		encoded, encodeErr := json.Marshal(result)
+		if encodeErr != nil {
+			return fmt.Errorf("failed to encode result of job %d: %w", job.Id, encodeErr)
+		}
+		job.Result = string(encoded)
+	}
+
+	// Progress is written by its own UPDATE statements, don't overwrite it with a stale value
+	if err := config.GetDB().Omit("progress").Save(job).Error; err != nil {
+		return fmt.Errorf("failed to update job %d: %w", job.Id, err)
+	}
+	return nil
+}
+
+// Recover enqueues the jobs left queued by a previous run of the service and fails the ones that
+// were interrupted while running, see FailExpired.
+func Recover(pool *Pool) error {
+	lease, err := config.GetEnvDuration("IMPORT_JOB_LEASE", defaultLease)
+	if err != nil {
+		return err
+	}
+	interrupted, err := FailExpired(lease)
+	if err != nil {
+		return err
+	}
+
+	var ids []uint
+	err = config.GetDB().Model(&models.ImportJob{}).Where("state = ?", models.JobStateQueued).Order("id").Pluck("id", &ids).Error
+	if err != nil {
+		return fmt.Errorf("failed to find queued jobs: %w", err)
+	}
+	for _, id := range ids {
+		if err := pool.Enqueue(id); err != nil {
+			return fmt.Errorf("failed to enqueue job %d: %w", id, err)
+		}
+	}
+
+	log.Printf("Recovered %d queued jobs, %d interrupted jobs marked as failed", len(ids), interrupted)
+	return nil
+}
+
+// FailExpired fails the running jobs whose claim wasn't renewed for lease, since their worker
+// stopped and their partial work was rolled back, and removes their uploads. Younger claims may
+// belong to another instance of the service still running them. It returns the number of jobs
+// failed.
+func FailExpired(lease time.Duration) (int, error) {
+	var interrupted []models.ImportJob
+	err := config.GetDB().Where("state = ? AND (claimed_at IS NULL OR claimed_at < ?)", models.JobStateRunning, time.Now().Add(-lease)).
+		Find(&interrupted).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find interrupted jobs: %w", err)
+	}
+	for i := range interrupted {
+		if err := Finish(&interrupted[i], nil, fmt.Errorf("interrupted before finishing")); err != nil {
+			return i, err
+		}
+		removeUpload(&interrupted[i])
+	}
+	return len(interrupted), nil
+}
+
+// safeRun calls run, turning a panic into an error so a bad job can't take down its worker.
+func safeRun(run RunFunc, job *models.ImportJob, progress func(rows int)) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return run(job, progress)
+}
@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when every slot of the queue is taken.
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrPoolClosed is returned by Enqueue once Shutdown was called.
+	ErrPoolClosed = errors.New("job pool is shut down")
+)
+
+// Pool runs queued jobs on a fixed number of worker goroutines.
+type Pool struct {
+	workers int            // Number of worker goroutines
+	queue   chan uint      // IDs of the jobs waiting for a worker
+	quit    chan struct{}  // Closed by Shutdown to stop the workers
+	run     func(id uint)  // Processes a single job
+	wg      sync.WaitGroup // Tracks the running workers
+	mu      sync.RWMutex   // Guards closed against concurrent Enqueue calls
+	closed  bool           // Set by Shutdown
+	tasks   []task         // Functions run periodically while the pool runs
+}
+
+// task is a function a Pool calls periodically.
+type task struct {
+	interval time.Duration // Time between calls
+	run      func()        // Function to call
+}
+
+// NewPool creates a Pool of workers goroutines with room for queueSize waiting jobs.
+// Every job is processed by calling run with its ID.
+func NewPool(workers, queueSize int, run func(id uint)) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &Pool{workers: workers, queue: make(chan uint, queueSize), quit: make(chan struct{}), run: run}
+}
+
+// Every registers run to be called every interval from the moment the pool is started until
+// Shutdown. It must be called before Start.
+func (p *Pool) Every(interval time.Duration, run func()) {
+	p.tasks = append(p.tasks, task{interval: interval, run: run})
+}
+
+// Start launches the workers and the periodic tasks.
+func (p *Pool) Start() {
+	for _, t := range p.tasks {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			ticker := time.NewTicker(t.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					t.run()
+				case <-p.quit:
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				// Check quit first so no new job is picked up once Shutdown was called
+				select {
+				case <-p.quit:
+					return
+				default:
+				}
+
+				select {
+				case id := <-p.queue:
+					p.run(id)
+				case <-p.quit:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Enqueue queues a job without blocking, returning ErrQueueFull when there is no room left.
+func (p *Pool) Enqueue(id uint) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.queue <- id:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops accepting jobs and the periodic tasks, and waits until the workers finish the
+// jobs they are running.
+// Jobs still waiting in the queue are left queued, to be picked up again by Recover.
+func (p *Pool) Shutdown() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.quit)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"errors"
+	"sort"
+	"stori_challenge/pkg/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPoolRunsQueuedJobs tests that every queued job is processed by the workers
+func TestPoolRunsQueuedJobs(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		ran []uint
+		wg  sync.WaitGroup
+	)
+	pool := NewPool(3, 10, func(id uint) {
+		defer wg.Done()
+		mu.Lock()
+		ran = append(ran, id)
+		mu.Unlock()
+	})
+	pool.Start()
+
+	for id := uint(1); id <= 10; id++ {
+		wg.Add(1)
+		if err := pool.Enqueue(id); err != nil {
+			t.Fatalf("Enqueue(%d): unexpected error %v", id, err)
+		}
+	}
+	wg.Wait()
+	pool.Shutdown()
+
+	sort.Slice(ran, func(i, j int) bool { return ran[i] < ran[j] })
+	if len(ran) != 10 || ran[0] != 1 || ran[9] != 10 {
+		t.Errorf("ran jobs %v, expected 1 to 10", ran)
+	}
+}
+
+// TestPoolRejectsWhenFull tests that Enqueue doesn't block when the queue is full or the pool is shut down
+func TestPoolRejectsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan uint, 1)
+	pool := NewPool(1, 1, func(id uint) {
+		started <- id
+		<-release
+	})
+	pool.Start()
+
+	if err := pool.Enqueue(1); err != nil {
+		t.Fatalf("Enqueue(1): unexpected error %v", err)
+	}
+	<-started // The only worker is busy with job 1
+
+	if err := pool.Enqueue(2); err != nil {
+		t.Fatalf("Enqueue(2): unexpected error %v", err)
+	}
+	if err := pool.Enqueue(3); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Enqueue(3) = %v, expected %v", err, ErrQueueFull)
+	}
+
+	close(release)
+	pool.Shutdown()
+	if err := pool.Enqueue(4); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Enqueue(4) = %v, expected %v", err, ErrPoolClosed)
+	}
+}
+
+// TestSafeRunRecoversPanics tests that a panicking job fails instead of crashing its worker
+func TestSafeRunRecoversPanics(t *testing.T) {
+	run := func(job *models.ImportJob, progress func(rows int)) (any, error) {
+		panic("boom")
+	}
+	if _, err := safeRun(run, &models.ImportJob{}, nil); err == nil {
+		t.Error("expected an error from a panicking job")
+	}
+}
+
+// TestPoolRunsPeriodicTasks tests that periodic tasks run while the pool runs and stop on Shutdown
+func TestPoolRunsPeriodicTasks(t *testing.T) {
+	ticks := make(chan struct{}, 10)
+	pool := NewPool(1, 0, func(uint) {})
+	pool.Every(time.Millisecond, func() {
+		select {
+		case ticks <- struct{}{}:
+		default:
+		}
+	})
+	pool.Start()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ticks:
+		case <-time.After(time.Second):
+			t.Fatalf("periodic task ran %d times, expected 3", i)
+		}
+	}
+	pool.Shutdown()
+
+	// Drain what ran before Shutdown; nothing runs afterwards
+	for len(ticks) > 0 {
+		<-ticks
+	}
+	time.Sleep(10 * time.Millisecond)
+	if len(ticks) != 0 {
+		t.Errorf("periodic task ran after Shutdown")
+	}
+}
@@ -1,31 +1,319 @@
 package models
 
+import (
+	"stori_challenge/pkg/money"
+	"time"
+)
+
+// Statuses of an ImportBatch.
+const (
+	ImportStatusProcessing = "processing" // The file is being imported
+	ImportStatusCompleted  = "completed"  // The file was imported
+	ImportStatusFailed     = "failed"     // The import stopped with an error
+	ImportStatusReverted   = "reverted"   // The batch rows were removed from the ledger
+)
+
+// States of an ImportJob.
+const (
+	JobStateQueued    = "queued"    // Waiting for a free worker
+	JobStateRunning   = "running"   // Being processed by a worker
+	JobStateSucceeded = "succeeded" // Processed, Result holds the outcome
+	JobStateFailed    = "failed"    // Stopped with an error, Error holds the reason
+)
+
+// States of an OutboxEmail.
+const (
+	OutboxStatePending = "pending" // Waiting for its next delivery attempt
+	OutboxStateSending = "sending" // Claimed by the dispatcher, delivery in progress
+	OutboxStateSent    = "sent"    // Delivered to the mail server
+	OutboxStateDead    = "dead"    // Given up after the maximum number of attempts
+)
+
 type (
-	// CSVDocument represents the structure of a CSV file entry with fields for ID, Date, and Transaction.
+	// Account represents the owner of a ledger; every SQLDocument belongs to exactly one account.
+	Account struct {
+		Id        uint      `gorm:"primaryKey" json:"id"`                        // Primary key for the account
+		Email     string    `gorm:"size:255;uniqueIndex;not null" json:"email"`  // Owner's email address, unique per account
+		Name      string    `gorm:"size:255" json:"name"`                        // Display name of the account owner
+		Currency  string    `gorm:"size:3;not null;default:USD" json:"currency"` // ISO 4217 code of the account's currency
+		Locale    string    `gorm:"size:8" json:"locale"`                        // Preferred locale of the summaries, empty for the default
+		CreatedAt time.Time `json:"createdAt"`                                   // Creation timestamp managed by GORM
+		UpdatedAt time.Time `json:"updatedAt"`                                   // Update timestamp managed by GORM
+	}
+
+	// ImportBatch records a single CSV upload so its rows can be audited or reverted later.
+	ImportBatch struct {
+		Id           uint       `gorm:"primaryKey" json:"id"`          // Primary key for the import batch
+		AccountId    uint       `gorm:"index" json:"accountId"`        // Account the rows were imported into
+		FileName     string     `gorm:"size:255" json:"fileName"`      // Original name of the uploaded file
+		Checksum     string     `gorm:"size:64;index" json:"checksum"` // SHA-256 checksum of the uploaded file
+		Uploader     string     `gorm:"size:255" json:"uploader"`      // Email address of the uploader
+		Profile      string     `gorm:"size:64" json:"profile"`        // Name of the import profile used to read the file
+		TotalRows    int        `json:"totalRows"`                     // Number of data rows read from the file
+		ImportedRows int        `json:"importedRows"`                  // Number of rows stored in the ledger
+		SkippedRows  int        `json:"skippedRows"`                   // Number of rows rejected or already present
+		Status       string     `gorm:"size:16;index" json:"status"`   // Current ImportStatus* value
+		StartedAt    time.Time  `json:"startedAt"`                     // When processing of the file started
+		FinishedAt   *time.Time `json:"finishedAt"`                    // When processing finished, nil while running
+		CreatedAt    time.Time  `json:"createdAt"`                     // Creation timestamp managed by GORM
+		UpdatedAt    time.Time  `json:"updatedAt"`                     // Update timestamp managed by GORM
+	}
+
+	// ImportJob tracks the asynchronous processing of an uploaded CSV file, from the upload until
+	// the summary email is sent, so clients can poll its state instead of waiting on the request.
+	ImportJob struct {
+		Id         uint       `gorm:"primaryKey" json:"id"`       // Primary key for the job
+		AccountId  uint       `gorm:"index" json:"accountId"`     // Account the file is imported into
+		State      string     `gorm:"size:16;index" json:"state"` // Current JobState* value
+		Progress   int        `json:"progress"`                   // Number of rows read from the file so far
+		Params     string     `gorm:"type:text" json:"-"`         // JSON encoded parameters of the job
+		Result     string     `gorm:"type:text" json:"-"`         // JSON encoded outcome, empty until the job finishes
+		Error      string     `gorm:"type:text" json:"error"`     // Reason the job failed, empty otherwise
+		StartedAt  *time.Time `json:"startedAt"`                  // When a worker picked up the job
+		ClaimedAt  *time.Time `gorm:"index" json:"claimedAt"`     // Last sign of life of the worker running the job, renewed with its progress
+		FinishedAt *time.Time `json:"finishedAt"`                 // When the job succeeded or failed
+		CreatedAt  time.Time  `json:"createdAt"`                  // Creation timestamp managed by GORM
+		UpdatedAt  time.Time  `json:"updatedAt"`                  // Update timestamp managed by GORM
+	}
+
+	// OutboxEmail is a summary email waiting to be delivered, stored in the same database as the
+	// import so it is never lost when the mail server is unavailable.
+	OutboxEmail struct {
+		Id            uint       `gorm:"primaryKey" json:"id"`                 // Primary key for the email
+		ImportBatchId uint       `gorm:"index" json:"importBatchId"`           // Import batch the summary was sent for
+		Recipient     string     `gorm:"size:255" json:"recipient"`            // Email address of the recipient
+		Payload       string     `gorm:"type:longtext" json:"-"`               // JSON encoded EmailData of the summary, with its statement entries
+		State         string     `gorm:"size:16;index" json:"state"`           // Current OutboxState* value
+		Attempts      int        `json:"attempts"`                             // Number of delivery attempts so far
+		NextAttemptAt time.Time  `gorm:"index" json:"nextAttemptAt"`           // When the next delivery attempt is due
+		ClaimedAt     *time.Time `gorm:"index" json:"claimedAt"`               // When a dispatcher started delivering it, nil unless sending
+		LastError     string     `gorm:"type:text" json:"lastError,omitempty"` // Error of the last failed attempt
+		SentAt        *time.Time `json:"sentAt"`                               // When the email was delivered, nil until then
+		CreatedAt     time.Time  `json:"createdAt"`                            // Creation timestamp managed by GORM
+		UpdatedAt     time.Time  `json:"updatedAt"`                            // Update timestamp managed by GORM
+	}
+
+	// CSVDocument represents the structure of a CSV file entry with fields for ID, Date, Transaction and Currency.
 	CSVDocument struct {
 		Id, Date, Transaction string // Fields for ID, transaction date, and transaction details
+		Currency              string // Optional currency of the transaction, empty for the account currency
+		Description           string // Optional description or merchant of the transaction
 	}
 
 	// SQLDocument represents the structure of a SQL database entry with fields for primary key and transaction details.
 	SQLDocument struct {
-		Id            uint    `gorm:"primaryKey"`    // Primary key for the SQL document
-		IdTransaction uint    `json:"idTransaction"` // Transaction ID for referencing the original transaction
-		Date          string  `gorm:"type:date"`     // Date of the transaction in a date format
-		Transaction   float64 `json:"transaction"`   // Transaction amount as a float
+		Id            uint         `gorm:"primaryKey"`                                                          // Primary key for the SQL document
+		AccountId     uint         `gorm:"uniqueIndex:idx_account_transaction,priority:1" json:"accountId"`     // Account that owns the transaction
+		ImportBatchId uint         `gorm:"index" json:"importBatchId"`                                          // Import batch the transaction came from
+		IdTransaction uint         `gorm:"uniqueIndex:idx_account_transaction,priority:2" json:"idTransaction"` // Transaction ID, unique within the account
+		Date          string       `gorm:"type:date"`                                                           // Date of the transaction in a date format
+		Transaction   money.Amount `gorm:"column:amount_cents;not null;default:0" json:"transaction"`           // Exact transaction amount in cents
+		Currency      string       `gorm:"size:3;index" json:"currency"`                                        // ISO 4217 code of the transaction amount
+		Description   string       `gorm:"size:255" json:"description"`                                         // Description or merchant of the transaction
+		Category      string       `gorm:"size:64;index" json:"category"`                                       // Category assigned by the account's rules, empty when none matched
+		Anomalies     string       `gorm:"size:64" json:"anomalies"`                                            // Comma separated anomaly flags raised on import, empty when the row looked normal
+	}
+
+	// OverwrittenRow keeps the values a ledger row had before an import overwrote it, so reverting
+	// the import can restore them. Only the first overwrite of a row by each import is kept.
+	OverwrittenRow struct {
+		Id            uint         `gorm:"primaryKey" json:"id"`                                           // Primary key for the record
+		ImportBatchId uint         `gorm:"uniqueIndex:idx_batch_document,priority:1" json:"importBatchId"` // Import that overwrote the row
+		SQLDocumentId uint         `gorm:"uniqueIndex:idx_batch_document,priority:2" json:"sqlDocumentId"` // Ledger row that was overwritten
+		Date          time.Time    `gorm:"type:date" json:"date"`                                          // Previous date of the transaction
+		Transaction   money.Amount `gorm:"column:amount_cents;not null;default:0" json:"transaction"`      // Previous amount in cents
+		Currency      string       `gorm:"size:3" json:"currency"`                                         // Previous ISO 4217 code of the amount
+		Description   string       `gorm:"size:255" json:"description"`                                    // Previous description
+		Category      string       `gorm:"size:64" json:"category"`                                        // Previous category
+		Anomalies     string       `gorm:"size:64" json:"anomalies"`                                       // Previous anomaly flags
+		CreatedAt     time.Time    `json:"createdAt"`                                                      // Creation timestamp managed by GORM
+	}
+
+	// CategoryRule assigns a category to the transactions of an account whose description or
+	// amount matches it. Rules are tried by ascending priority and the first match wins.
+	CategoryRule struct {
+		Id        uint          `gorm:"primaryKey" json:"id"`                               // Primary key for the rule
+		AccountId uint          `gorm:"index" json:"accountId"`                             // Account the rule applies to
+		Category  string        `gorm:"size:64" json:"category"`                            // Category assigned to matching transactions
+		Kind      string        `gorm:"size:16" json:"kind"`                                // How the rule matches: keyword, regex or amount
+		Pattern   string        `gorm:"size:255" json:"pattern,omitempty"`                  // Keyword or regular expression matched against the description
+		MinAmount *money.Amount `gorm:"column:min_amount_cents" json:"minAmount,omitempty"` // Smallest matching amount, signed, nil for no lower bound
+		MaxAmount *money.Amount `gorm:"column:max_amount_cents" json:"maxAmount,omitempty"` // Largest matching amount, signed, nil for no upper bound
+		Priority  int           `json:"priority"`                                           // Rules with a lower priority are tried first
+		CreatedAt time.Time     `json:"createdAt"`                                          // Creation time
+		UpdatedAt time.Time     `json:"updatedAt"`                                          // Time of the last change
+	}
+
+	// Budget limits the monthly spending of an account, in the account currency, either in one
+	// category or across all of them.
+	Budget struct {
+		Id        uint         `gorm:"primaryKey" json:"id"`                                                // Primary key for the budget
+		AccountId uint         `gorm:"uniqueIndex:idx_account_category,priority:1" json:"accountId"`        // Account the budget applies to
+		Category  string       `gorm:"size:64;uniqueIndex:idx_account_category,priority:2" json:"category"` // Category limited by the budget, empty for all spending
+		Limit     money.Amount `gorm:"column:limit_cents" json:"limit"`                                     // Spending allowed per month
+		CreatedAt time.Time    `json:"createdAt"`                                                           // Creation time
+		UpdatedAt time.Time    `json:"updatedAt"`                                                           // Time of the last change
+	}
+
+	// BudgetAlert records an import that pushed the spending of a month over a budget.
+	BudgetAlert struct {
+		Id            uint         `gorm:"primaryKey" json:"id"`            // Primary key for the alert
+		AccountId     uint         `gorm:"index" json:"accountId"`          // Account the budget belongs to
+		BudgetId      uint         `gorm:"index" json:"budgetId"`           // Budget that was exceeded
+		ImportBatchId uint         `gorm:"index" json:"importBatchId"`      // Import that pushed the month over the budget
+		Category      string       `gorm:"size:64" json:"category"`         // Category of the budget, empty for all spending
+		Year          int          `json:"year"`                            // Year of the month over budget
+		Month         time.Month   `json:"month"`                           // Month over budget
+		Limit         money.Amount `gorm:"column:limit_cents" json:"limit"` // Limit of the budget when the alert was raised
+		Spent         money.Amount `gorm:"column:spent_cents" json:"spent"` // Spending of the month after the import
+		CreatedAt     time.Time    `json:"createdAt"`                       // Time the alert was raised
 	}
 
-	// TransactionsByMonth holds the total number of transactions and the corresponding month.
+	// CurrencyTotals aggregates the transactions of a ledger that share the same currency.
+	CurrencyTotals struct {
+		Currency    string       // ISO 4217 code of the amounts
+		Balance     money.Amount // Sum of all transactions
+		DebitTotal  money.Amount // Sum of debit transactions
+		DebitCount  int64        // Number of debit transactions
+		CreditTotal money.Amount // Sum of credit transactions
+		CreditCount int64        // Number of credit transactions
+	}
+
+	// CurrencyAmount is the amount of a single transaction in the currency it was recorded in.
+	CurrencyAmount struct {
+		Currency string       // ISO 4217 code of the amount
+		Amount   money.Amount // Amount of the transaction
+	}
+
+	// AmountStats describes the distribution of the amounts of a ledger in one currency.
+	AmountStats struct {
+		Currency      string       // ISO 4217 code of the amounts
+		Count         int64        // Number of transactions
+		DebitCount    int64        // Number of debit transactions
+		CreditCount   int64        // Number of credit transactions
+		MinAmount     money.Amount // Smallest amount
+		MaxAmount     money.Amount // Largest amount
+		LargestDebit  money.Amount // Debit with the largest magnitude, 0 without debits
+		LargestCredit money.Amount // Largest credit, 0 without credits
+		Mean          float64      // Arithmetic mean, in cents
+		Variance      float64      // Population variance, in squared cents
+	}
+
+	// Statistics describes the distribution of the transactions of a summary, in the reporting currency.
+	Statistics struct {
+		DebitCount     int64        `json:"debitCount"`     // Number of debit transactions
+		CreditCount    int64        `json:"creditCount"`    // Number of credit transactions
+		MedianAmount   money.Amount `json:"medianAmount"`   // Median amount of all transactions
+		MinAmount      money.Amount `json:"minAmount"`      // Smallest amount of all transactions
+		MaxAmount      money.Amount `json:"maxAmount"`      // Largest amount of all transactions
+		LargestDebit   money.Amount `json:"largestDebit"`   // Debit with the largest magnitude, 0 without debits
+		LargestCredit  money.Amount `json:"largestCredit"`  // Largest credit, 0 without credits
+		StdDevAmount   money.Amount `json:"stdDevAmount"`   // Population standard deviation of all amounts
+		ClosingBalance money.Amount `json:"closingBalance"` // Balance of the whole ledger at the end of the period
+	}
+
+	// CategoryTotals aggregates the transactions of a ledger in one category and currency.
+	CategoryTotals struct {
+		Category    string       // Category of the transactions, empty for uncategorized ones
+		Currency    string       // ISO 4217 code of the amounts
+		Count       int64        // Number of transactions
+		DebitTotal  money.Amount // Sum of debit transactions
+		CreditTotal money.Amount // Sum of credit transactions
+	}
+
+	// TransactionsByCategory holds the number of transactions of a category and their amounts in the reporting currency.
+	TransactionsByCategory struct {
+		Category    string       `json:"category"`    // Category of the transactions, empty for uncategorized ones
+		Total       int64        `json:"total"`       // Total number of transactions in the category
+		DebitTotal  money.Amount `json:"debitTotal"`  // Sum of debit transactions
+		CreditTotal money.Amount `json:"creditTotal"` // Sum of credit transactions
+		Net         money.Amount `json:"net"`         // Credits plus debits
+	}
+
+	// RecurringTransaction is a debit repeated at a regular interval, such as a subscription.
+	RecurringTransaction struct {
+		Description string       `json:"description"` // Description of the latest occurrence, empty when the rows had none
+		Currency    string       `json:"currency"`    // ISO 4217 code of the amount
+		Amount      money.Amount `json:"amount"`      // Typical amount, the median of the occurrences
+		Frequency   string       `json:"frequency"`   // Interval between occurrences: weekly or monthly
+		Occurrences int          `json:"occurrences"` // Number of occurrences found in the ledger
+		LastDate    string       `json:"lastDate"`    // Date of the latest occurrence, formatted as YYYY-MM-DD
+		NextDate    string       `json:"nextDate"`    // Predicted date of the next occurrence, formatted as YYYY-MM-DD
+	}
+
+	// CurrencyBalance is the balance held in one currency, along with its value in the reporting currency.
+	CurrencyBalance struct {
+		Currency  string       `json:"currency"`  // ISO 4217 code of the balance
+		Balance   money.Amount `json:"balance"`   // Balance in its own currency
+		Converted money.Amount `json:"converted"` // Balance converted into the reporting currency
+	}
+
+	// Period bounds the transactions of a summary; an empty date leaves that side unbounded.
+	Period struct {
+		Preset string `json:"preset,omitempty"` // Preset the period was built from, such as "quarter"
+		From   string `json:"from,omitempty"`   // First day of the period, formatted as YYYY-MM-DD
+		To     string `json:"to,omitempty"`     // Last day of the period, formatted as YYYY-MM-DD
+	}
+
+	// MonthlyTotals aggregates the transactions of a ledger in one month and currency.
+	MonthlyTotals struct {
+		Year        int          // Year of the transactions
+		Month       time.Month   // Month of the transactions
+		Currency    string       // ISO 4217 code of the amounts
+		Count       int64        // Number of transactions
+		DebitTotal  money.Amount // Sum of debit transactions
+		CreditTotal money.Amount // Sum of credit transactions
+	}
+
+	// TransactionsByMonth holds the number of transactions of a month and their amounts in the reporting currency.
 	TransactionsByMonth struct {
-		Total int64  // Total number of transactions for the month
-		Month string // Name of the month
+		Year        int          `json:"year"`        // Year of the transactions
+		Month       time.Month   `json:"month"`       // Month of the transactions, named in the recipient's locale by the templates
+		Total       int64        `json:"total"`       // Total number of transactions for the month
+		DebitTotal  money.Amount `json:"debitTotal"`  // Sum of debit transactions
+		CreditTotal money.Amount `json:"creditTotal"` // Sum of credit transactions
+		Net         money.Amount `json:"net"`         // Credits plus debits
+	}
+
+	// FlaggedTransaction is an imported transaction that deviates from the history of its account.
+	FlaggedTransaction struct {
+		Date          string       `json:"date"`          // Date of the transaction, formatted as YYYY-MM-DD
+		IdTransaction uint         `json:"idTransaction"` // Transaction ID within the account
+		Description   string       `json:"description"`   // Description or merchant, may be empty
+		Amount        money.Amount `json:"amount"`        // Amount in its own currency
+		Currency      string       `json:"currency"`      // ISO 4217 code of the amount
+		Flags         []string     `json:"flags"`         // Anomalies found: amount, weekday or duplicate
+	}
+
+	// StatementEntry is a transaction listed in an account statement.
+	StatementEntry struct {
+		Date          string       `json:"date"`          // Date of the transaction, formatted as YYYY-MM-DD
+		IdTransaction uint         `json:"idTransaction"` // Transaction ID within the account
+		Amount        money.Amount `json:"amount"`        // Amount in its own currency
+		Currency      string       `json:"currency"`      // ISO 4217 code of the amount
+		Description   string       `json:"description"`   // Description of the transaction, such as the merchant
+		Category      string       `json:"category"`      // Category of the transaction, empty for uncategorized ones
 	}
 
-	// EmailData holds the information required for sending an email report.
+	// EmailData holds the information required for sending an email report. It is also the JSON
+	// summary of an account, without the recipient.
 	EmailData struct {
-		EmailTo             string                // Recipient's email address
-		TotalBalance        float64               // Total balance amount
-		AverageDebitAmount  float64               // Average amount of debit transactions
-		AverageCreditAmount float64               // Average amount of credit transactions
-		Transactions        []TransactionsByMonth // List of transactions aggregated by month
+		EmailTo             string                   `json:"emailTo,omitempty"`          // Recipient's email address
+		Currency            string                   `json:"currency"`                   // Reporting currency of the amounts below
+		Period              Period                   `json:"period"`                     // Period the figures cover
+		Balances            []CurrencyBalance        `json:"balances"`                   // Balance held in each currency
+		TotalBalance        money.Amount             `json:"totalBalance"`               // Total balance amount
+		AverageDebitAmount  money.Amount             `json:"averageDebitAmount"`         // Average amount of debit transactions
+		AverageCreditAmount money.Amount             `json:"averageCreditAmount"`        // Average amount of credit transactions
+		Statistics          Statistics               `json:"statistics"`                 // Further statistics of the transactions
+		Transactions        []TransactionsByMonth    `json:"transactions"`               // List of transactions aggregated by month, oldest first
+		Categories          []TransactionsByCategory `json:"categories"`                 // List of transactions aggregated by category, largest spending first
+		Alerts              []BudgetAlert            `json:"alerts,omitempty"`           // Budgets the import pushed over their limit
+		Anomalies           []FlaggedTransaction     `json:"anomalies,omitempty"`        // Imported transactions that look unusual, oldest first
+		Recurring           []RecurringTransaction   `json:"recurring,omitempty"`        // Recurring debits found in the ledger, next due first
+		Attachments         []string                 `json:"attachments,omitempty"`      // Statement formats attached to the email, such as "csv" or "pdf"
+		Entries             []StatementEntry         `json:"entries,omitempty"`          // Transactions listed in the attached statements, oldest first
+		EntriesTruncated    bool                     `json:"entriesTruncated,omitempty"` // Entries holds only the most recent transactions of the ledger
+		Locale              string                   `json:"locale,omitempty"`           // Locale the email is written in, see the i18n package
 	}
 )
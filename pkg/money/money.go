@@ -0,0 +1,155 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of decimal places kept by an Amount.
+const Scale = 2
+
+// unitsPerMajor is the number of minor units in one major unit (10^Scale).
+const unitsPerMajor = 100
+
+// ErrInvalidAmount is returned when a string is not a valid decimal amount.
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ErrInvalidCurrency is returned when a currency code is not a three-letter ISO 4217 code.
+var ErrInvalidCurrency = errors.New("invalid currency code")
+
+// Amount is an exact monetary value expressed in minor units (cents), so sums and
+// comparisons never suffer from floating point rounding.
+type Amount int64
+
+// Parse converts a decimal string such as "+60.5", "-10.30" or "10" into an Amount without
+// going through floating point. Digits beyond Scale decimal places must be zeros.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+
+	negative := false
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	// Drop trailing zeros beyond the supported precision, reject any other extra digit
+	if len(fraction) > Scale {
+		if strings.Trim(fraction[Scale:], "0") != "" {
+			return 0, fmt.Errorf("%w: %q has more than %d decimal places", ErrInvalidAmount, s, Scale)
+		}
+		fraction = fraction[:Scale]
+	}
+	fraction += strings.Repeat("0", Scale-len(fraction))
+
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+	if negative {
+		units = -units
+	}
+	return Amount(units), nil
+}
+
+// isDigits reports whether s only contains ASCII digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the amount with exactly Scale decimal places, e.g. "-10.30".
+func (a Amount) String() string {
+	sign := ""
+	units := int64(a)
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, units/unitsPerMajor, Scale, units%unitsPerMajor)
+}
+
+// Div divides the amount by n, rounding half away from zero. Dividing by zero returns zero.
+func (a Amount) Div(n int64) Amount {
+	if n == 0 {
+		return 0
+	}
+
+	negative := (a < 0) != (n < 0)
+	num, den := abs(int64(a)), abs(n)
+
+	quotient, remainder := num/den, num%den
+	if remainder*2 >= den {
+		quotient++
+	}
+	if negative {
+		quotient = -quotient
+	}
+	return Amount(quotient)
+}
+
+// MulRat multiplies the amount by the exact ratio r, rounding half away from zero to the cent.
+func (a Amount) MulRat(r *big.Rat) Amount {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(a)), r)
+
+	// Round half away from zero: truncate |product| + 1/2
+	num := new(big.Int).Abs(product.Num())
+	den := product.Denom()
+	num.Mul(num, big.NewInt(2)).Add(num, den)
+	num.Quo(num, new(big.Int).Mul(den, big.NewInt(2)))
+	if product.Sign() < 0 {
+		num.Neg(num)
+	}
+	return Amount(num.Int64())
+}
+
+// ParseCurrency validates a three-letter ISO 4217 currency code and returns it in upper case.
+func ParseCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCurrency, code)
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", fmt.Errorf("%w: %q", ErrInvalidCurrency, code)
+		}
+	}
+	return code, nil
+}
+
+// abs returns the absolute value of n.
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// MarshalJSON encodes the amount as an exact JSON number such as 39.74.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON decodes an amount from a JSON number or string.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	parsed, err := Parse(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
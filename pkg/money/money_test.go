@@ -0,0 +1,118 @@
+package money
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+// parsePair defines a structure for holding amount parsing test cases.
+type parsePair struct {
+	input    string // Amount as written in the CSV file
+	expected Amount // Expected amount in minor units
+	hasErr   bool   // Indicates if an error is expected for this test case
+}
+
+// List of test cases with corresponding expected outcomes
+var parseTests = []parsePair{
+	{"+60.5", 6050, false},
+	{"-10.3", -1030, false},
+	{"10", 1000, false},
+	{"-20.46", -2046, false},
+	{".5", 50, false},
+	{"7.", 700, false},
+	{"1.2300", 123, false}, // Trailing zeros beyond the scale are accepted
+	{"0.1", 10, false},
+	{"1.234", 0, true}, // Would lose precision
+	{"", 0, true},
+	{"-", 0, true},
+	{"1,5", 0, true},
+	{"1e3", 0, true},
+	{"99999999999999999999", 0, true}, // Out of range
+}
+
+// TestParse tests the Parse function with various inputs
+func TestParse(t *testing.T) {
+	for _, pair := range parseTests {
+		amount, err := Parse(pair.input)
+
+		// Check if the result matches the expected outcome
+		if (err != nil) != pair.hasErr || amount != pair.expected {
+			t.Errorf("For %q expected (%d, error: %v), got (%d, %v)", pair.input, pair.expected, pair.hasErr, amount, err)
+		}
+	}
+}
+
+// TestString tests that amounts are formatted with exactly two decimal places
+func TestString(t *testing.T) {
+	tests := map[Amount]string{
+		3974:  "39.74",
+		-1538: "-15.38",
+		5:     "0.05",
+		-5:    "-0.05",
+		0:     "0.00",
+	}
+	for amount, expected := range tests {
+		if got := amount.String(); got != expected {
+			t.Errorf("For %d expected %q, got %q", int64(amount), expected, got)
+		}
+	}
+}
+
+// TestDiv tests that division rounds half away from zero
+func TestDiv(t *testing.T) {
+	tests := []struct {
+		amount   Amount
+		n        int64
+		expected Amount
+	}{
+		{-3076, 2, -1538},
+		{7050, 2, 3525},
+		{100, 3, 33},
+		{200, 3, 67},
+		{-200, 3, -67},
+		{5, 2, 3},
+		{-5, 2, -3},
+		{100, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Div(tt.n); got != tt.expected {
+			t.Errorf("For %d/%d expected %d, got %d", int64(tt.amount), tt.n, tt.expected, got)
+		}
+	}
+}
+
+// TestJSON tests that amounts round-trip through JSON as exact numbers
+func TestJSON(t *testing.T) {
+	data, err := json.Marshal(struct{ Total Amount }{Total: -1538})
+	if err != nil || string(data) != `{"Total":-15.38}` {
+		t.Fatalf("unexpected JSON %s (%v)", data, err)
+	}
+
+	var decoded struct{ Total Amount }
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Total != -1538 {
+		t.Errorf("unexpected decoded amount %d (%v)", decoded.Total, err)
+	}
+}
+
+// TestMulRat tests exact conversion with rounding half away from zero
+func TestMulRat(t *testing.T) {
+	tests := []struct {
+		amount   Amount
+		rate     string
+		expected Amount
+	}{
+		{10000, "1.0825", 10825},
+		{-1030, "0.5", -515},
+		{1, "0.5", 1},   // 0.005 rounds up
+		{-1, "0.5", -1}, // -0.005 rounds away from zero
+		{3, "1/3", 1},
+		{6050, "17.1234", 103597},
+	}
+	for _, tt := range tests {
+		rate, _ := new(big.Rat).SetString(tt.rate)
+		if got := tt.amount.MulRat(rate); got != tt.expected {
+			t.Errorf("For %d*%s expected %d, got %d", int64(tt.amount), tt.rate, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package outbox
+
+import (
+	"fmt"
+	"log"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/models"
+	"sync"
+	"time"
+)
+
+// Defaults of the Dispatcher, overridable with the OUTBOX_* environment variables.
+const (
+	defaultPollInterval = 5 * time.Second  // How often due emails are looked up
+	defaultBaseDelay    = 30 * time.Second // Delay after the first failed attempt, doubled after each one
+	defaultMaxDelay     = 6 * time.Hour    // Upper bound of the delay between attempts
+	defaultMaxAttempts  = 10               // Attempts before an email is moved to the dead state
+	defaultLease        = 10 * time.Minute // Time a claimed email may stay sending before another dispatcher retries it
+	dispatchBatchSize   = 50               // Emails claimed per poll
+)
+
+// SendFunc delivers a summary email.
+type SendFunc func(data models.EmailData) error
+
+// Dispatcher delivers the emails of the outbox in the background, retrying failed deliveries
+// with exponential backoff until they are sent or run out of attempts.
+type Dispatcher struct {
+	send         SendFunc       // Delivers a single email
+	pollInterval time.Duration  // How often due emails are looked up
+	baseDelay    time.Duration  // Delay after the first failed attempt
+	maxDelay     time.Duration  // Upper bound of the delay between attempts
+	maxAttempts  int            // Attempts before giving up on an email
+	lease        time.Duration  // Time a claim lasts before the email is released
+	quit         chan struct{}  // Closed by Shutdown to stop the dispatcher
+	wg           sync.WaitGroup // Tracks the dispatching goroutine
+}
+
+// NewDispatcher creates a Dispatcher that delivers emails with send, configured by
+// OUTBOX_POLL_INTERVAL, OUTBOX_BASE_DELAY, OUTBOX_MAX_DELAY, OUTBOX_MAX_ATTEMPTS and OUTBOX_LEASE.
+func NewDispatcher(send SendFunc) (*Dispatcher, error) {
+	d := &Dispatcher{send: send, quit: make(chan struct{})}
+
+	var err error
+	if d.pollInterval, err = config.GetEnvDuration("OUTBOX_POLL_INTERVAL", defaultPollInterval); err != nil {
+		return nil, err
+	}
+	if d.baseDelay, err = config.GetEnvDuration("OUTBOX_BASE_DELAY", defaultBaseDelay); err != nil {
+		return nil, err
+	}
+	if d.maxDelay, err = config.GetEnvDuration("OUTBOX_MAX_DELAY", defaultMaxDelay); err != nil {
+		return nil, err
+	}
+	if d.maxAttempts, err = config.GetEnvInt("OUTBOX_MAX_ATTEMPTS", defaultMaxAttempts); err != nil {
+		return nil, err
+	}
+	if d.lease, err = config.GetEnvDuration("OUTBOX_LEASE", defaultLease); err != nil {
+		return nil, err
+	}
+	if d.maxAttempts < 1 {
+		d.maxAttempts = 1
+	}
+	return d, nil
+}
+
+// Start releases the expired claims left by a previous run and starts delivering due emails.
+func (d *Dispatcher) Start() error {
+	if err := d.releaseExpired(time.Now()); err != nil {
+		return err
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			d.dispatchDue()
+			select {
+			case <-ticker.C:
+			case <-d.quit:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the dispatcher once the emails being delivered are done.
+func (d *Dispatcher) Shutdown() {
+	close(d.quit)
+	d.wg.Wait()
+}
+
+// releaseExpired returns to pending the emails claimed longer than the lease ago. Their delivery
+// was interrupted, by a crash or a restart of the instance sending them, and may or may not have
+// reached the server; retry it. Emails another instance is still delivering keep their claim.
+func (d *Dispatcher) releaseExpired(now time.Time) error {
+	err := config.GetDB().Model(&models.OutboxEmail{}).
+		Where("state = ? AND (claimed_at IS NULL OR claimed_at < ?)", models.OutboxStateSending, now.Add(-d.lease)).
+		Updates(map[string]any{"state": models.OutboxStatePending, "claimed_at": nil}).Error
+	if err != nil {
+		return fmt.Errorf("failed to release interrupted emails: %w", err)
+	}
+	return nil
+}
+
+// dispatchDue delivers the pending emails whose next attempt is due.
+func (d *Dispatcher) dispatchDue() {
+	db := config.GetDB()
+	if err := d.releaseExpired(time.Now()); err != nil {
+		log.Println("Error:", err)
+	}
+
+	var due []models.OutboxEmail
+	err := db.Where("state = ? AND next_attempt_at <= ?", models.OutboxStatePending, time.Now()).
+		Order("next_attempt_at").Limit(dispatchBatchSize).Find(&due).Error
+	if err != nil {
+		log.Printf("Error finding due emails: %v", err)
+		return
+	}
+
+	for i := range due {
+		email := &due[i]
+
+		// Claim the email so another instance of the service doesn't deliver it too
+		claim := db.Model(email).Where("state = ?", models.OutboxStatePending).
+			Updates(map[string]any{"state": models.OutboxStateSending, "claimed_at": time.Now()})
+		if claim.Error != nil {
+			log.Printf("Error claiming email %d: %v", email.Id, claim.Error)
+			continue
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		data, err := decode(email)
+		if err == nil {
+			err = d.send(data)
+		}
+		d.recordAttempt(email, err, time.Now())
+		email.ClaimedAt = nil
+		if err := db.Save(email).Error; err != nil {
+			log.Printf("Error updating email %d: %v", email.Id, err)
+		}
+	}
+}
+
+// recordAttempt updates an email with the outcome of a delivery attempt made at now, scheduling
+// the next attempt or moving it to the dead state when the attempt failed.
+func (d *Dispatcher) recordAttempt(email *models.OutboxEmail, err error, now time.Time) {
+	email.Attempts++
+	if err == nil {
+		email.State = models.OutboxStateSent
+		email.SentAt = &now
+		email.LastError = ""
+		return
+	}
+
+	email.LastError = err.Error()
+	if email.Attempts >= d.maxAttempts {
+		email.State = models.OutboxStateDead
+		log.Printf("Email %d to %s is dead after %d attempts: %v", email.Id, email.Recipient, email.Attempts, err)
+		return
+	}
+	email.State = models.OutboxStatePending
+	email.NextAttemptAt = now.Add(backoff(d.baseDelay, d.maxDelay, email.Attempts))
+}
+
+// backoff returns the delay before the next attempt after the given number of failed attempts:
+// base, then twice as long after each failure, never exceeding limit.
+func backoff(base, limit time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
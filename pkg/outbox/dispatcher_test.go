@@ -0,0 +1,60 @@
+package outbox
+
+import (
+	"errors"
+	"stori_challenge/pkg/models"
+	"testing"
+	"time"
+)
+
+// backoffPair defines a structure for holding backoff test cases.
+type backoffPair struct {
+	attempts int           // Failed attempts so far
+	expected time.Duration // Expected delay before the next attempt
+}
+
+// List of test cases with a base delay of 30s and a maximum of 10m
+var backoffTests = []backoffPair{
+	{1, 30 * time.Second},
+	{2, time.Minute},
+	{3, 2 * time.Minute},
+	{5, 8 * time.Minute},
+	{6, 10 * time.Minute}, // Capped
+	{100, 10 * time.Minute},
+}
+
+// TestBackoff tests that the delay doubles after each failed attempt up to the maximum
+func TestBackoff(t *testing.T) {
+	for _, pair := range backoffTests {
+		if got := backoff(30*time.Second, 10*time.Minute, pair.attempts); got != pair.expected {
+			t.Errorf("backoff after %d attempts = %v, expected %v", pair.attempts, got, pair.expected)
+		}
+	}
+}
+
+// TestRecordAttempt tests the transitions of an email through retries, delivery and the dead state
+func TestRecordAttempt(t *testing.T) {
+	d := &Dispatcher{baseDelay: time.Minute, maxDelay: time.Hour, maxAttempts: 3}
+	now := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	smtpErr := errors.New("connection refused")
+
+	email := &models.OutboxEmail{State: models.OutboxStateSending}
+	d.recordAttempt(email, smtpErr, now)
+	if email.State != models.OutboxStatePending || email.Attempts != 1 || !email.NextAttemptAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("after a failed attempt got state %s, attempts %d, next attempt %v", email.State, email.Attempts, email.NextAttemptAt)
+	}
+	if email.LastError != smtpErr.Error() {
+		t.Errorf("LastError = %q, expected %q", email.LastError, smtpErr.Error())
+	}
+
+	d.recordAttempt(email, nil, now)
+	if email.State != models.OutboxStateSent || email.SentAt == nil || email.LastError != "" {
+		t.Errorf("after a delivery got state %s, sent at %v, last error %q", email.State, email.SentAt, email.LastError)
+	}
+
+	email = &models.OutboxEmail{Attempts: 2}
+	d.recordAttempt(email, smtpErr, now)
+	if email.State != models.OutboxStateDead || email.Attempts != 3 {
+		t.Errorf("after the last attempt got state %s, attempts %d, expected %s", email.State, email.Attempts, models.OutboxStateDead)
+	}
+}
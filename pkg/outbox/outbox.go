@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/models"
+	"time"
+)
+
+// Enqueue stores a summary email for delivery by the Dispatcher, due immediately.
+func Enqueue(importBatchId uint, data models.EmailData) (models.OutboxEmail, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return models.OutboxEmail{}, fmt.Errorf("failed to encode email: %w", err)
+	}
+
+	email := models.OutboxEmail{
+		ImportBatchId: importBatchId,
+		Recipient:     data.EmailTo,
+		Payload:       string(payload),
+		State:         models.OutboxStatePending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := config.GetDB().Create(&email).Error; err != nil {
+		return models.OutboxEmail{}, fmt.Errorf("failed to enqueue email: %w", err)
+	}
+	return email, nil
+}
+
+// ForImport returns the summary emails queued for an import batch, oldest first.
+func ForImport(importBatchId uint) ([]models.OutboxEmail, error) {
+	var emails []models.OutboxEmail
+	err := config.GetDB().Where("import_batch_id = ?", importBatchId).Order("id").Find(&emails).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get emails of import batch %d: %w", importBatchId, err)
+	}
+	return emails, nil
+}
+
+// decode returns the EmailData stored in an outbox email.
+func decode(email *models.OutboxEmail) (models.EmailData, error) {
+	var data models.EmailData
+	if err := json.Unmarshal([]byte(email.Payload), &data); err != nil {
+		return models.EmailData{}, fmt.Errorf("invalid payload of email %d: %w", email.Id, err)
+	}
+	return data, nil
+}
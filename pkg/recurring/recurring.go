@@ -0,0 +1,183 @@
+package recurring
+
+import (
+	"cmp"
+	"slices"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Frequencies of recurring transactions.
+const (
+	FrequencyWeekly  = "weekly"  // About every 7 days
+	FrequencyMonthly = "monthly" // About every month, on a similar day
+)
+
+// Limits of the detection.
+const (
+	minOccurrences  = 3  // Occurrences needed before a series counts as recurring
+	amountTolerance = 10 // Percentage an occurrence's amount may differ from the typical amount
+	dateLayout      = "2006-01-02"
+)
+
+type (
+	// transaction is a debit of the ledger considered by the detection.
+	transaction struct {
+		Date        time.Time    // Date of the transaction
+		Description string       // Description or merchant, may be empty
+		Amount      money.Amount // Amount of the debit, negative
+		Currency    string       // ISO 4217 code of the amount
+	}
+
+	// cadence describes the gaps between the occurrences of a frequency.
+	cadence struct {
+		frequency        string // FrequencyWeekly or FrequencyMonthly
+		minDays, maxDays int    // Accepted gap between consecutive occurrences
+		graceDays        int    // Days a predicted occurrence may be late before the series is considered over
+	}
+
+	// seriesKey groups the transactions that may belong to the same series.
+	seriesKey struct {
+		merchant string       // Normalized description, empty when the rows had none
+		amount   money.Amount // Exact amount of rows without a description, 0 otherwise
+		currency string       // ISO 4217 code of the amounts
+	}
+)
+
+// cadences are tried in order for every series.
+var cadences = []cadence{
+	{frequency: FrequencyWeekly, minDays: 6, maxDays: 8, graceDays: 3},
+	{frequency: FrequencyMonthly, minDays: 27, maxDays: 33, graceDays: 7},
+}
+
+// detect finds the debits repeated at a weekly or monthly interval with the same merchant, or the
+// same amount when they have no description, and predicts their next occurrence. Series whose
+// next occurrence is overdue as of now are considered cancelled and left out. The result is
+// ordered by the date of the next occurrence.
+func detect(transactions []transaction, now time.Time) []models.RecurringTransaction {
+	groups := map[seriesKey][]transaction{}
+	for _, t := range transactions {
+		if t.Amount >= 0 {
+			continue // Only debits recur as payments
+		}
+		key := seriesKey{merchant: normalizeMerchant(t.Description), currency: t.Currency}
+		if key.merchant == "" {
+			key.amount = t.Amount
+		}
+		groups[key] = append(groups[key], t)
+	}
+
+	found := []models.RecurringTransaction{}
+	for _, group := range groups {
+		if r, ok := series(group, now); ok {
+			found = append(found, r)
+		}
+	}
+	slices.SortFunc(found, func(a, b models.RecurringTransaction) int {
+		if c := cmp.Compare(a.NextDate, b.NextDate); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Description, b.Description)
+	})
+	return found
+}
+
+// series checks whether a group of transactions recurs at a regular interval with similar
+// amounts, returning the recurring transaction it describes.
+func series(group []transaction, now time.Time) (models.RecurringTransaction, bool) {
+	if len(group) < minOccurrences {
+		return models.RecurringTransaction{}, false
+	}
+	slices.SortFunc(group, func(a, b transaction) int { return a.Date.Compare(b.Date) })
+
+	// Every occurrence needs an amount close to the typical one
+	typical := median(group)
+	for _, t := range group {
+		if diff := abs(t.Amount - typical); diff*100 > abs(typical)*amountTolerance {
+			return models.RecurringTransaction{}, false
+		}
+	}
+
+	for _, c := range cadences {
+		if !c.matches(group) {
+			continue
+		}
+
+		last := group[len(group)-1]
+		next := c.next(last.Date)
+		if now.After(next.AddDate(0, 0, c.graceDays)) {
+			return models.RecurringTransaction{}, false // The series stopped
+		}
+		return models.RecurringTransaction{
+			Description: last.Description,
+			Currency:    last.Currency,
+			Amount:      typical,
+			Frequency:   c.frequency,
+			Occurrences: len(group),
+			LastDate:    last.Date.Format(dateLayout),
+			NextDate:    next.Format(dateLayout),
+		}, true
+	}
+	return models.RecurringTransaction{}, false
+}
+
+// matches reports whether every gap between consecutive occurrences fits the cadence.
+func (c cadence) matches(group []transaction) bool {
+	for i := 1; i < len(group); i++ {
+		days := int(group[i].Date.Sub(group[i-1].Date).Hours() / 24)
+		if days < c.minDays || days > c.maxDays {
+			return false
+		}
+	}
+	return true
+}
+
+// next predicts the occurrence following last. Monthly series keep the day of the month, moved to
+// the last day of shorter months.
+func (c cadence) next(last time.Time) time.Time {
+	if c.frequency == FrequencyWeekly {
+		return last.AddDate(0, 0, 7)
+	}
+
+	year, month, day := last.Date()
+	firstOfNext := time.Date(year, month+1, 1, 0, 0, 0, 0, last.Location())
+	if daysInNext := firstOfNext.AddDate(0, 1, -1).Day(); day > daysInNext {
+		day = daysInNext
+	}
+	return time.Date(year, month+1, day, 0, 0, 0, 0, last.Location())
+}
+
+// normalizeMerchant reduces a description to the words that identify the merchant, dropping
+// case, digits and punctuation so that "NETFLIX.COM 8675" and "Netflix.com 1234" match.
+func normalizeMerchant(description string) string {
+	words := strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// median returns the median amount of a group, rounding half away from zero.
+func median(group []transaction) money.Amount {
+	amounts := make([]money.Amount, len(group))
+	for i, t := range group {
+		amounts[i] = t.Amount
+	}
+	slices.Sort(amounts)
+
+	n := len(amounts)
+	if n%2 == 1 {
+		return amounts[n/2]
+	}
+	return (amounts[n/2-1] + amounts[n/2]).Div(2)
+}
+
+// abs returns the magnitude of an amount.
+func abs(a money.Amount) money.Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,96 @@
+package recurring
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+)
+
+// debit returns a transaction on the given date of 2024.
+func debit(month time.Month, day int, description string, cents int64) transaction {
+	return transaction{
+		Date:        time.Date(2024, month, day, 0, 0, 0, 0, time.UTC),
+		Description: description,
+		Amount:      money.Amount(cents),
+		Currency:    "USD",
+	}
+}
+
+// TestDetect tests that weekly and monthly debits are detected and their next occurrence predicted
+func TestDetect(t *testing.T) {
+	transactions := []transaction{
+		// Monthly subscription with varying reference numbers, on the 31st when the month has it
+		debit(time.May, 31, "NETFLIX.COM 1234", -1549),
+		debit(time.June, 30, "Netflix.com 5678", -1549),
+		debit(time.July, 31, "NETFLIX.COM 9012", -1549),
+		// Weekly debit without a description, grouped by amount
+		debit(time.July, 12, "", -2500),
+		debit(time.July, 19, "", -2500),
+		debit(time.July, 26, "", -2500),
+		debit(time.August, 2, "", -2500),
+		// Same merchant, but amounts too different to be a subscription
+		debit(time.June, 1, "Super Market", -4500),
+		debit(time.July, 1, "Super Market", -12000),
+		debit(time.August, 1, "Super Market", -3000),
+		// Monthly, but cancelled: the next occurrence is long overdue
+		debit(time.March, 5, "Gym", -3000),
+		debit(time.April, 5, "Gym", -3000),
+		debit(time.May, 5, "Gym", -3000),
+		// Only two occurrences
+		debit(time.July, 3, "Spotify", -999),
+		debit(time.August, 3, "Spotify", -999),
+		// Irregular intervals
+		debit(time.June, 2, "Parking", -800),
+		debit(time.June, 20, "Parking", -800),
+		debit(time.August, 1, "Parking", -800),
+		// Credits never recur as payments
+		debit(time.June, 15, "Payroll", 250000),
+		debit(time.July, 15, "Payroll", 250000),
+		debit(time.August, 15, "Payroll", 250000),
+	}
+
+	now := time.Date(2024, time.August, 5, 12, 0, 0, 0, time.UTC)
+	expected := []models.RecurringTransaction{
+		{Description: "", Currency: "USD", Amount: -2500, Frequency: FrequencyWeekly, Occurrences: 4, LastDate: "2024-08-02", NextDate: "2024-08-09"},
+		{Description: "NETFLIX.COM 9012", Currency: "USD", Amount: -1549, Frequency: FrequencyMonthly, Occurrences: 3, LastDate: "2024-07-31", NextDate: "2024-08-31"},
+	}
+	if found := detect(transactions, now); !reflect.DeepEqual(found, expected) {
+		t.Errorf("detect() = %+v, expected %+v", found, expected)
+	}
+}
+
+// List of monthly predictions
+var nextTests = []struct {
+	last, expected time.Time // Latest occurrence and predicted next one
+}{
+	{time.Date(2024, time.July, 15, 0, 0, 0, 0, time.UTC), time.Date(2024, time.August, 15, 0, 0, 0, 0, time.UTC)},
+	{time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC), time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	{time.Date(2024, time.December, 31, 0, 0, 0, 0, time.UTC), time.Date(2025, time.January, 31, 0, 0, 0, 0, time.UTC)},
+}
+
+// TestNextMonthly tests that monthly predictions keep the day of the month
+func TestNextMonthly(t *testing.T) {
+	monthly := cadences[1]
+	for _, test := range nextTests {
+		if got := monthly.next(test.last); !got.Equal(test.expected) {
+			t.Errorf("next(%s) = %s, expected %s", test.last.Format(dateLayout), got.Format(dateLayout), test.expected.Format(dateLayout))
+		}
+	}
+}
+
+// TestNormalizeMerchant tests that reference numbers and punctuation don't split a merchant
+func TestNormalizeMerchant(t *testing.T) {
+	for description, expected := range map[string]string{
+		"NETFLIX.COM 8675": "netflix com",
+		"Uber *Trip 12":    "uber trip",
+		"  ":               "",
+		"#1234":            "",
+	} {
+		if got := normalizeMerchant(description); got != expected {
+			t.Errorf("normalizeMerchant(%q) = %q, expected %q", description, got, expected)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package recurring
+
+import (
+	"fmt"
+	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/models"
+	"time"
+)
+
+// lookbackMonths is how far back the ledger is searched for recurring debits.
+const lookbackMonths = 13
+
+// ForAccount finds the recurring debits of an account among its transactions of the last
+// lookbackMonths months, as of now.
+func ForAccount(accountId uint, now time.Time) ([]models.RecurringTransaction, error) {
+	var transactions []transaction
+	err := config.GetDB().Model(&models.SQLDocument{}).
+		Select("date, description, amount_cents AS amount, currency").
+		Where("account_id = ? AND amount_cents < 0 AND date >= ?", accountId, now.AddDate(0, -lookbackMonths, 0).Format(dateLayout)).
+		Order("date, id_transaction").
+		Scan(&transactions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get debits of account %d: %w", accountId, err)
+	}
+	return detect(transactions, now), nil
+}
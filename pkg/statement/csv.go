@@ -0,0 +1,32 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"stori_challenge/pkg/models"
+	"strconv"
+)
+
+// CSV renders the transactions of a summary with the Id,Date,Transaction,Currency,Description
+// header of uploads, followed by their Category, so the file can be imported again as it is.
+func CSV(data models.EmailData) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write([]string{"Id", "Date", "Transaction", "Currency", "Description", "Category"}); err != nil {
+		return nil, fmt.Errorf("failed to write statement header: %w", err)
+	}
+	for _, e := range data.Entries {
+		row := []string{strconv.FormatUint(uint64(e.IdTransaction), 10), e.Date, e.Amount.String(), e.Currency, e.Description, e.Category}
+		if err := w.Write(row); err != nil {
+			return nil, fmt.Errorf("failed to write statement row: %w", err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write statement: %w", err)
+	}
+	return buf.Bytes(), nil
+}
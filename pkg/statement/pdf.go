@@ -0,0 +1,169 @@
+package statement
+
+import (
+	"bytes"
+	"fmt"
+	"stori_challenge/pkg/i18n"
+	"stori_challenge/pkg/models"
+	"strings"
+)
+
+// Layout of the PDF statement, in points on an A4 page.
+const (
+	pageWidth        = 595 // Width of an A4 page
+	pageHeight       = 842 // Height of an A4 page
+	marginLeft       = 50  // Left margin of every line
+	marginTop        = 60  // Distance from the top of the page to the first line
+	lineHeight       = 14  // Distance between lines
+	fontSize         = 10  // Size of the body text
+	titleSize        = 16  // Size of the title
+	linesPerPage     = 52  // Lines that fit between the margins
+	labelWidth       = 23  // Width of the labels of the summary, in characters
+	categoryWidth    = 14  // Width of the category column, in characters
+	descriptionWidth = 27  // Width of the description column, the last one of the table
+)
+
+// pdfLine is a line of text of the statement.
+type pdfLine struct {
+	text string // Text of the line
+	size int    // Font size of the line
+}
+
+// PDF renders a printable statement with the summary and the transactions of a summary. The
+// document is written by hand with the standard Courier font, which every PDF reader provides,
+// so columns line up without font metrics and no dependency is needed.
+func PDF(data models.EmailData) []byte {
+	lines := statementLines(data)
+
+	// Split the lines into pages
+	var pages [][]pdfLine
+	for len(lines) > linesPerPage {
+		pages = append(pages, lines[:linesPerPage])
+		lines = lines[linesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects 1 to 3 are the catalog, the page tree and the font; every page adds a page object
+	// followed by its content stream
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // Page tree, written once the page objects are numbered
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	}
+	kids := make([]string, len(pages))
+	for i, page := range pages {
+		pageId := len(objects) + 1
+		kids[i] = fmt.Sprintf("%d 0 R", pageId)
+
+		content := pageContent(page)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pageWidth, pageHeight, pageId+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	// Write the objects, recording their offsets for the cross-reference table
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n") // The binary comment marks the file as binary
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// statementLines lays out the statement in the locale of the summary: the summary followed by
+// the table of transactions.
+func statementLines(data models.EmailData) []pdfLine {
+	locale, err := i18n.Parse(data.Locale)
+	if err != nil {
+		locale = i18n.English
+	}
+	text := func(key string, args ...any) string { return i18n.Text(locale, key, args...) }
+	body := func(format string, args ...any) pdfLine {
+		return pdfLine{text: fmt.Sprintf(format, args...), size: fontSize}
+	}
+
+	lines := []pdfLine{{text: text("statement.title"), size: titleSize}, body("")}
+	lines = append(lines, body("%-*s%s", labelWidth, text("statement.account"), data.EmailTo))
+	if from, to := Period(data); from != "" {
+		lines = append(lines, body("%-*s%s", labelWidth, text("statement.period"), text("statement.period_range", from, to)))
+	}
+	lines = append(lines,
+		body("%-*s%s %s", labelWidth, text("statement.total_balance"), data.TotalBalance, data.Currency),
+		body("%-*s%s %s", labelWidth, text("statement.average_debit"), data.AverageDebitAmount, data.Currency),
+		body("%-*s%s %s", labelWidth, text("statement.average_credit"), data.AverageCreditAmount, data.Currency),
+	)
+	if len(data.Balances) > 1 {
+		for _, b := range data.Balances {
+			lines = append(lines, body("%-*s%s %s (%s %s)", labelWidth, text("statement.balance_in", b.Currency), b.Balance, b.Currency, b.Converted, data.Currency))
+		}
+	}
+
+	lines = append(lines, body(""),
+		body("%-10s %8s %14s %-4s %-*s %s", text("statement.date"), text("statement.id"), text("statement.amount"), text("statement.currency"),
+			categoryWidth, text("statement.category"), text("statement.description")),
+		body("%s", strings.Repeat("-", 82)))
+	for _, e := range data.Entries {
+		lines = append(lines, body("%-10s %8d %14s %-4s %-*s %s", e.Date, e.IdTransaction, e.Amount, e.Currency,
+			categoryWidth, truncate(e.Category, categoryWidth), truncate(e.Description, descriptionWidth)))
+	}
+	if len(data.Entries) == 0 {
+		lines = append(lines, body("%s", text("statement.empty")))
+	}
+	if data.EntriesTruncated {
+		lines = append(lines, body(""), body("%s", text("statement.truncated", len(data.Entries))))
+	}
+	return lines
+}
+
+// truncate shortens text to at most width characters, marking the cut with a period.
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	return strings.TrimRight(string(runes[:width-1]), " ") + "."
+}
+
+// pageContent returns the content stream drawing the lines of a page from the top.
+func pageContent(lines []pdfLine) string {
+	var content strings.Builder
+	y := pageHeight - marginTop
+	for _, line := range lines {
+		if line.text != "" {
+			fmt.Fprintf(&content, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", line.size, marginLeft, y, escapePDF(line.text))
+		}
+		y -= lineHeight
+	}
+	return strings.TrimSuffix(content.String(), "\n")
+}
+
+// escapePDF encodes text as the content of a PDF literal string in WinAnsiEncoding, escaping
+// the delimiters and replacing the characters the encoding can't represent.
+func escapePDF(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r)) // Latin-1 characters share their code in WinAnsiEncoding
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package statement
+
+import (
+	"fmt"
+	"stori_challenge/pkg/models"
+)
+
+// Statement formats that can be attached to the summary email.
+const (
+	FormatCSV = "csv" // Normalized transaction list, importable with the default profile
+	FormatPDF = "pdf" // Printable statement with the summary and the transaction list
+)
+
+// File is a rendered statement.
+type File struct {
+	Name        string // File name offered to the recipient
+	ContentType string // MIME type of Data
+	Data        []byte // Content of the file
+}
+
+// Render renders the statement of a summary in the given format.
+func Render(format string, data models.EmailData) (File, error) {
+	switch format {
+	case FormatCSV:
+		content, err := CSV(data)
+		if err != nil {
+			return File{}, err
+		}
+		return File{Name: fileName(data, "csv"), ContentType: "text/csv; charset=utf-8", Data: content}, nil
+	case FormatPDF:
+		return File{Name: fileName(data, "pdf"), ContentType: "application/pdf", Data: PDF(data)}, nil
+	}
+	return File{}, fmt.Errorf("unknown statement format: %s", format)
+}
+
+// Period returns the dates of the first and last transactions listed in the statement.
+func Period(data models.EmailData) (from, to string) {
+	if len(data.Entries) == 0 {
+		return "", ""
+	}
+	return data.Entries[0].Date, data.Entries[len(data.Entries)-1].Date
+}
+
+// fileName names the statement after its period, e.g. statement_2024-07-15_2024-08-30.pdf.
+func fileName(data models.EmailData, extension string) string {
+	from, to := Period(data)
+	if from == "" {
+		return "statement." + extension
+	}
+	return fmt.Sprintf("statement_%s_%s.%s", from, to, extension)
+}
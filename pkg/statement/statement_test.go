@@ -0,0 +1,130 @@
+package statement
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"stori_challenge/pkg/models"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// data is the summary the statements are rendered from.
+var data = models.EmailData{
+	EmailTo:             "hector.gonzalez.olmos@gmail.com",
+	Currency:            "USD",
+	TotalBalance:        3974,
+	AverageDebitAmount:  -1538,
+	AverageCreditAmount: 3525,
+	Entries: []models.StatementEntry{
+		{Date: "2024-07-15", IdTransaction: 0, Amount: 6000, Currency: "USD", Description: "Payroll, July", Category: "Income"},
+		{Date: "2024-07-28", IdTransaction: 1, Amount: -1046, Currency: "USD", Description: "Whole Foods Market", Category: "Groceries"},
+		{Date: "2024-08-02", IdTransaction: 2, Amount: -2040, Currency: "MXN"},
+		{Date: "2024-08-13", IdTransaction: 3, Amount: 1050, Currency: "USD"},
+	},
+}
+
+// TestCSV tests that the CSV statement uses the header and format of uploads
+func TestCSV(t *testing.T) {
+	content, err := CSV(data)
+	if err != nil {
+		t.Fatalf("CSV: unexpected error %v", err)
+	}
+
+	expected := "Id,Date,Transaction,Currency,Description,Category\n" +
+		"0,2024-07-15,60.00,USD,\"Payroll, July\",Income\n" +
+		"1,2024-07-28,-10.46,USD,Whole Foods Market,Groceries\n" +
+		"2,2024-08-02,-20.40,MXN,,\n" +
+		"3,2024-08-13,10.50,USD,,\n"
+	if string(content) != expected {
+		t.Errorf("CSV =\n%s\nexpected\n%s", content, expected)
+	}
+}
+
+// TestPDF tests that the PDF statement is well formed and paginated
+func TestPDF(t *testing.T) {
+	long := data
+	long.Entries = nil
+	for i := 0; i < 120; i++ {
+		long.Entries = append(long.Entries, models.StatementEntry{Date: "2024-07-15", IdTransaction: uint(i), Amount: 100, Currency: "USD"})
+	}
+
+	content := PDF(long)
+	if !bytes.HasPrefix(content, []byte("%PDF-1.4")) || !bytes.HasSuffix(content, []byte("%%EOF\n")) {
+		t.Fatalf("missing PDF header or trailer")
+	}
+
+	// startxref points at the cross-reference table, whose entries point at their objects
+	match := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(content)
+	if match == nil {
+		t.Fatalf("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(content[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+	lines := strings.Split(string(content[xref:]), "\n")
+	count, _ := strconv.Atoi(strings.Fields(lines[1])[1])
+	for i := 1; i < count; i++ {
+		offset, _ := strconv.Atoi(lines[2+i][:10])
+		if prefix := fmt.Sprintf("%d 0 obj", i); !bytes.HasPrefix(content[offset:], []byte(prefix)) {
+			t.Errorf("xref entry %d points at %q", i, content[offset:offset+10])
+		}
+	}
+
+	// 120 transactions and the summary don't fit in two pages of 52 lines
+	if !bytes.Contains(content, []byte("/Count 3")) {
+		t.Errorf("expected 3 pages")
+	}
+}
+
+// TestStatementLines tests that the labels of the PDF statement follow the locale of the summary
+func TestStatementLines(t *testing.T) {
+	for locale, expected := range map[string][]string{
+		"es":    {"Estado de cuenta", "Saldo total:", "Fecha", "Importe", "Moneda", "Categoría", "Descripción", "Whole Foods Market"},
+		"en":    {"Account statement", "Total balance:", "Date", "Amount", "Currency", "Category", "Description", "Groceries"},
+		"es-MX": {"Estado de cuenta"},
+		"fr":    {"Account statement"}, // Unsupported locales fall back to English
+	} {
+		localized := data
+		localized.Locale = locale
+		var text strings.Builder
+		for _, line := range statementLines(localized) {
+			text.WriteString(line.text + "\n")
+		}
+		for _, label := range expected {
+			if !strings.Contains(text.String(), label) {
+				t.Errorf("%s statement doesn't contain %q:\n%s", locale, label, text.String())
+			}
+		}
+	}
+}
+
+// TestTruncate tests that long descriptions and categories are cut to their column
+func TestTruncate(t *testing.T) {
+	if got := truncate("Supermercado Soriana", 14); got != "Supermercado." {
+		t.Errorf("truncate = %q", got)
+	}
+	if got := truncate("Café", 4); got != "Café" {
+		t.Errorf("truncate = %q", got)
+	}
+}
+
+// TestEscapePDF tests the encoding of text in PDF literal strings
+func TestEscapePDF(t *testing.T) {
+	if got := escapePDF(`Héctor (a\b) 日`); got != "H\xe9ctor \\(a\\\\b\\) ?" {
+		t.Errorf("escapePDF = %q", got)
+	}
+}
+
+// TestRender tests the names and types of the rendered statements
+func TestRender(t *testing.T) {
+	file, err := Render(FormatPDF, data)
+	if err != nil || file.Name != "statement_2024-07-15_2024-08-13.pdf" || file.ContentType != "application/pdf" {
+		t.Errorf("Render(pdf) = %s %s (%v)", file.Name, file.ContentType, err)
+	}
+	if _, err := Render("xls", data); err == nil {
+		t.Error("Render(xls): expected an error")
+	}
+}
@@ -0,0 +1,76 @@
+package summary
+
+import (
+	"errors"
+	"fmt"
+	"stori_challenge/pkg/models"
+	"strings"
+	"time"
+)
+
+// Period presets, each ending today.
+const (
+	PeriodAll     = "all"     // Every transaction of the ledger
+	PeriodMonth   = "month"   // From the first day of the current month
+	PeriodQuarter = "quarter" // From the first day of the current quarter
+	PeriodYTD     = "ytd"     // From January 1st of the current year
+	PeriodYear    = "year"    // The last twelve months
+)
+
+// dateLayout is the format of the dates of a Period.
+const dateLayout = "2006-01-02"
+
+// ErrInvalidPeriod is returned when a period is unknown, malformed or ends before it starts.
+var ErrInvalidPeriod = errors.New("invalid period")
+
+// ParsePeriod builds the period of a summary from a preset or from explicit from/to dates
+// (YYYY-MM-DD, either may be empty), relative to now. Without any of them the period covers
+// every transaction.
+func ParsePeriod(preset, from, to string, now time.Time) (models.Period, error) {
+	preset = strings.ToLower(strings.TrimSpace(preset))
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+
+	if preset != "" && preset != PeriodAll {
+		if from != "" || to != "" {
+			return models.Period{}, fmt.Errorf("%w: a preset can't be combined with from/to dates", ErrInvalidPeriod)
+		}
+		return presetPeriod(preset, now)
+	}
+
+	// Explicit dates, validated and normalized
+	for _, date := range []*string{&from, &to} {
+		if *date == "" {
+			continue
+		}
+		t, err := time.Parse(dateLayout, *date)
+		if err != nil {
+			return models.Period{}, fmt.Errorf("%w: date %q is not YYYY-MM-DD", ErrInvalidPeriod, *date)
+		}
+		*date = t.Format(dateLayout)
+	}
+	if from != "" && to != "" && from > to {
+		return models.Period{}, fmt.Errorf("%w: %s is after %s", ErrInvalidPeriod, from, to)
+	}
+	return models.Period{From: from, To: to}, nil
+}
+
+// presetPeriod returns the period of a preset ending today.
+func presetPeriod(preset string, now time.Time) (models.Period, error) {
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+
+	var start time.Time
+	switch preset {
+	case PeriodMonth:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+	case PeriodQuarter:
+		start = time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, now.Location())
+	case PeriodYTD:
+		start = time.Date(year, time.January, 1, 0, 0, 0, 0, now.Location())
+	case PeriodYear:
+		start = today.AddDate(-1, 0, 1)
+	default:
+		return models.Period{}, fmt.Errorf("%w: unknown preset %q", ErrInvalidPeriod, preset)
+	}
+	return models.Period{Preset: preset, From: start.Format(dateLayout), To: today.Format(dateLayout)}, nil
+}
@@ -0,0 +1,107 @@
+package summary
+
+import (
+	"fmt"
+	"math"
+	"stori_challenge/pkg/exchange"
+	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+)
+
+// convertStats converts the distribution of the amounts of a currency into the reporting currency.
+// The bounds are converted exactly; the mean and variance are scaled by the rate.
+func convertStats(rates exchange.RateProvider, s models.AmountStats, to string) (models.AmountStats, float64, error) {
+	from := s.Currency
+	if from == "" || from == to {
+		return s, 1, nil // Rows imported before currencies were tracked are in the reporting currency
+	}
+
+	rate, err := rates.Rate(from, to)
+	if err != nil {
+		return models.AmountStats{}, 0, err
+	}
+	scale, _ := rate.Float64()
+
+	converted := s
+	converted.MinAmount = s.MinAmount.MulRat(rate)
+	converted.MaxAmount = s.MaxAmount.MulRat(rate)
+	converted.LargestDebit = s.LargestDebit.MulRat(rate)
+	converted.LargestCredit = s.LargestCredit.MulRat(rate)
+	converted.Mean = s.Mean * scale
+	converted.Variance = s.Variance * scale * scale
+	return converted, scale, nil
+}
+
+// describe merges the distributions of the amounts of every currency, already converted into the
+// reporting currency. The median and closing balance depend on rows that aren't aggregated and are
+// left for the caller.
+func describe(amounts []models.AmountStats) models.Statistics {
+	var stats models.Statistics
+	var count int64
+	var sum float64
+	for _, a := range amounts {
+		if a.Count == 0 {
+			continue
+		}
+		if count == 0 || a.MinAmount < stats.MinAmount {
+			stats.MinAmount = a.MinAmount
+		}
+		if count == 0 || a.MaxAmount > stats.MaxAmount {
+			stats.MaxAmount = a.MaxAmount
+		}
+		stats.LargestDebit = min(stats.LargestDebit, a.LargestDebit)
+		stats.LargestCredit = max(stats.LargestCredit, a.LargestCredit)
+		stats.DebitCount += a.DebitCount
+		stats.CreditCount += a.CreditCount
+		count += a.Count
+		sum += a.Mean * float64(a.Count)
+	}
+	if count == 0 {
+		return stats // Nothing to describe
+	}
+
+	// Population standard deviation of the merged amounts, from the variance and mean of each
+	// currency, rounded to the cent
+	mean := sum / float64(count)
+	var squares float64
+	for _, a := range amounts {
+		squares += float64(a.Count) * (a.Variance + (a.Mean-mean)*(a.Mean-mean))
+	}
+	stats.StdDevAmount = money.Amount(math.Round(math.Sqrt(squares / float64(count))))
+	return stats
+}
+
+// median returns the median of the amounts of the period in the reporting currency, reading only
+// the one or two middle transactions of the count amounts. scales orders the amounts of the other
+// currencies as if they were converted.
+func median(provider SummaryProvider, rates exchange.RateProvider, currency string, period models.Period, scales map[string]float64, count int64) (money.Amount, error) {
+	if count == 0 {
+		return 0, nil
+	}
+	offset, limit := count/2, 1
+	if count%2 == 0 {
+		offset, limit = count/2-1, 2 // Halfway between the middle amounts
+	}
+
+	middle, err := provider.AmountsAt(period, scales, int(offset), limit)
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving the median amount: %w", err)
+	}
+
+	var sum money.Amount
+	for _, a := range middle {
+		from := a.Currency
+		if from == "" {
+			from = currency
+		}
+		converted, err := exchange.Convert(rates, a.Amount, from, currency)
+		if err != nil {
+			return 0, fmt.Errorf("error converting %s amount: %w", from, err)
+		}
+		sum += converted
+	}
+	if len(middle) == 2 {
+		return sum.Div(2), nil
+	}
+	return sum, nil
+}
@@ -1,139 +1,440 @@
 package summary
 
 import (
+	"cmp"
 	"fmt"
 	"log"
+	"slices"
 	"stori_challenge/pkg/config"
+	"stori_challenge/pkg/exchange"
 	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // SummaryProvider defines the methods required for generating a financial summary.
 type (
 	SummaryProvider interface {
-		TotalBalance() (float64, error)                                   // Method to retrieve the total balance
-		AverageDebitAmount() (float64, error)                             // Method to retrieve the average debit amount
-		AverageCreditAmount() (float64, error)                            // Method to retrieve the average credit amount
-		NumberTransactionsInMonth() ([]models.TransactionsByMonth, error) // Method to retrieve transactions by month
+		CurrencyTotals(period models.Period) ([]models.CurrencyTotals, error)                                          // Method to retrieve balances and debit/credit totals per currency
+		MonthlyTotals(period models.Period) ([]models.MonthlyTotals, error)                                            // Method to retrieve counts and totals per month and currency
+		AmountStats(period models.Period) ([]models.AmountStats, error)                                                // Method to retrieve the distribution of the amounts per currency
+		AmountsAt(period models.Period, scales map[string]float64, offset, limit int) ([]models.CurrencyAmount, error) // Method to retrieve the transactions at a position of the ordered amounts
+		CategoryTotals(period models.Period) ([]models.CategoryTotals, error)                                          // Method to retrieve counts and totals per category and currency
+	}
+
+	// StatementProvider supplies the transactions listed in account statements.
+	StatementProvider interface {
+		Entries(period models.Period, limit int) ([]models.StatementEntry, error) // Method to retrieve the most recent transactions, newest first
 	}
 
-	FinanceService struct{} // Empty struct used for the FinanceService implementation
+	// FinanceService implements SummaryProvider and StatementProvider over the ledger of a single account.
+	FinanceService struct {
+		AccountId uint // Account whose transactions are aggregated
+	}
 )
 
-// CreateSummary generates a financial summary based on the provided data.
-func CreateSummary(provider SummaryProvider) (models.EmailData, error) {
-	// Retrieve the total balance and handle potential errors
-	total, err := provider.TotalBalance()
-	if err != nil {
-		return models.EmailData{}, fmt.Errorf("error calculating total balance: %w", err)
+// NewFinanceService returns a FinanceService scoped to the given account.
+func NewFinanceService(accountId uint) *FinanceService {
+	return &FinanceService{AccountId: accountId}
+}
+
+// ledger returns a query over the SQLDocument rows that belong to the service's account and
+// fall within the period.
+func (f *FinanceService) ledger(period models.Period) *gorm.DB {
+	query := config.GetDB().Model(&models.SQLDocument{}).Where("account_id = ?", f.AccountId)
+	if period.From != "" {
+		query = query.Where("date >= ?", period.From)
+	}
+	if period.To != "" {
+		query = query.Where("date <= ?", period.To)
 	}
-	log.Printf("The total balance is: %.2f", total) // Log the total balance
+	return query
+}
 
-	// Retrieve the average debit amount and handle potential errors
-	avgDebit, err := provider.AverageDebitAmount()
+// CreateSummary generates a financial summary of the transactions in the period based on the
+// provided data. Amounts held in other currencies are converted into the reporting currency with
+// rates before being aggregated.
+func CreateSummary(provider SummaryProvider, rates exchange.RateProvider, currency string, period models.Period) (models.EmailData, error) {
+	// Retrieve the totals of each currency and handle potential errors
+	totals, err := provider.CurrencyTotals(period)
 	if err != nil {
-		return models.EmailData{}, fmt.Errorf("error calculating average debit amount: %w", err)
+		return models.EmailData{}, fmt.Errorf("error calculating currency totals: %w", err)
+	}
+
+	var (
+		balances                = []models.CurrencyBalance{} // Empty rather than null in the JSON summary
+		total, debits, credits  money.Amount
+		debitCount, creditCount int64
+	)
+	for _, t := range totals {
+		from := t.Currency
+		if from == "" {
+			from = currency // Rows imported before currencies were tracked
+		}
+
+		balance, err := exchange.Convert(rates, t.Balance, from, currency)
+		if err != nil {
+			return models.EmailData{}, fmt.Errorf("error converting %s balance: %w", from, err)
+		}
+		debit, err := exchange.Convert(rates, t.DebitTotal, from, currency)
+		if err != nil {
+			return models.EmailData{}, fmt.Errorf("error converting %s debits: %w", from, err)
+		}
+		credit, err := exchange.Convert(rates, t.CreditTotal, from, currency)
+		if err != nil {
+			return models.EmailData{}, fmt.Errorf("error converting %s credits: %w", from, err)
+		}
+
+		balances = append(balances, models.CurrencyBalance{Currency: from, Balance: t.Balance, Converted: balance})
+		total += balance
+		debits += debit
+		credits += credit
+		debitCount += t.DebitCount
+		creditCount += t.CreditCount
 	}
-	log.Printf("The average debit amount is: %.2f", avgDebit) // Log the average debit amount
 
-	// Retrieve the average credit amount and handle potential errors
-	avgCredit, err := provider.AverageCreditAmount()
+	avgDebit := debits.Div(debitCount)
+	avgCredit := credits.Div(creditCount)
+	log.Printf("The total balance is: %s %s", total, currency)             // Log the total balance
+	log.Printf("The average debit amount is: %s %s", avgDebit, currency)   // Log the average debit amount
+	log.Printf("The average credit amount is: %s %s", avgCredit, currency) // Log the average credit amount
+
+	// Describe the distribution of the amounts and the balance at the end of the period
+	stats, err := statistics(provider, rates, currency, period, total)
 	if err != nil {
-		return models.EmailData{}, fmt.Errorf("error calculating average credit amount: %w", err)
+		return models.EmailData{}, err
 	}
-	log.Printf("The average credit amount is: %.2f", avgCredit) // Log the average credit amount
 
-	// Retrieve the number of transactions in each month and handle potential errors
-	transactions, err := provider.NumberTransactionsInMonth()
+	// Retrieve the totals of each month and handle potential errors
+	monthly, err := provider.MonthlyTotals(period)
 	if err != nil {
-		return models.EmailData{}, fmt.Errorf("error retrieving number of transactions in month: %w", err)
+		return models.EmailData{}, fmt.Errorf("error retrieving monthly totals: %w", err)
+	}
+	transactions, err := mergeMonths(monthly, rates, currency)
+	if err != nil {
+		return models.EmailData{}, err
 	}
 	log.Printf("The transactions in the month are: %v", transactions) // Log the transactions by month
 
+	// Retrieve the totals of each category and handle potential errors
+	byCategory, err := provider.CategoryTotals(period)
+	if err != nil {
+		return models.EmailData{}, fmt.Errorf("error retrieving category totals: %w", err)
+	}
+	categories, err := mergeCategories(byCategory, rates, currency)
+	if err != nil {
+		return models.EmailData{}, err
+	}
+
 	// Return the compiled summary data
 	return models.EmailData{
+		Currency:            currency,
+		Period:              period,
+		Balances:            balances,
 		TotalBalance:        total,
 		AverageDebitAmount:  avgDebit,
 		AverageCreditAmount: avgCredit,
+		Statistics:          stats,
 		Transactions:        transactions,
+		Categories:          categories,
 	}, nil
 }
 
-// TotalBalance calculates the total balance from the SQLDocument model.
-func (f *FinanceService) TotalBalance() (float64, error) {
-	var total float64
-	// Query to sum all transactions in the SQLDocument model
-	if err := config.GetDB().Model(&models.SQLDocument{}).Select("SUM(transaction)").Scan(&total).Error; err != nil {
-		return 0, fmt.Errorf("failed to get total transaction: %w", err)
+// statistics converts the distribution of the amounts of each currency in the period into the
+// reporting currency and describes them, reading only the middle transactions for the median.
+// The closing balance is the period's balance when the period has no start, and the balance of
+// every transaction up to the end of the period otherwise.
+func statistics(provider SummaryProvider, rates exchange.RateProvider, currency string, period models.Period, balance money.Amount) (models.Statistics, error) {
+	amounts, err := provider.AmountStats(period)
+	if err != nil {
+		return models.Statistics{}, fmt.Errorf("error retrieving transaction amounts: %w", err)
 	}
-	return total, nil // Return the total balance
-}
 
-// AverageDebitAmount calculates the average debit amount from the SQLDocument model.
-func (f *FinanceService) AverageDebitAmount() (float64, error) {
-	var avg float64
-	// Query to calculate the average of debit transactions (where transaction < 0)
-	if err := config.GetDB().Model(&models.SQLDocument{}).Where("transaction < ?", 0).Select("AVG(transaction)").Scan(&avg).Error; err != nil {
-		return 0, fmt.Errorf("failed to get average debit transaction: %w", err)
+	var count int64
+	scales := map[string]float64{}
+	for i, a := range amounts {
+		var scale float64
+		if amounts[i], scale, err = convertStats(rates, a, currency); err != nil {
+			return models.Statistics{}, fmt.Errorf("error converting %s amounts: %w", a.Currency, err)
+		}
+		if scale != 1 {
+			scales[a.Currency] = scale
+		}
+		count += a.Count
+	}
+	stats := describe(amounts)
+	if stats.MedianAmount, err = median(provider, rates, currency, period, scales, count); err != nil {
+		return models.Statistics{}, err
 	}
-	return avg, nil // Return the average debit amount
-}
 
-// AverageCreditAmount calculates the average credit amount from the SQLDocument model.
-func (f *FinanceService) AverageCreditAmount() (float64, error) {
-	var avg float64
-	// Query to calculate the average of credit transactions (where transaction > 0)
-	if err := config.GetDB().Model(&models.SQLDocument{}).Where("transaction > ?", 0).Select("AVG(transaction)").Scan(&avg).Error; err != nil {
-		return 0, fmt.Errorf("failed to get average credit transaction: %w", err)
+	stats.ClosingBalance = balance
+	if period.From != "" {
+		totals, err := provider.CurrencyTotals(models.Period{To: period.To})
+		if err != nil {
+			return models.Statistics{}, fmt.Errorf("error calculating closing balance: %w", err)
+		}
+		stats.ClosingBalance = 0
+		for _, t := range totals {
+			from := t.Currency
+			if from == "" {
+				from = currency
+			}
+			closing, err := exchange.Convert(rates, t.Balance, from, currency)
+			if err != nil {
+				return models.Statistics{}, fmt.Errorf("error converting %s closing balance: %w", from, err)
+			}
+			stats.ClosingBalance += closing
+		}
 	}
-	return avg, nil // Return the average credit amount
+	log.Printf("The closing balance is: %s %s", stats.ClosingBalance, currency) // Log the closing balance
+	return stats, nil
 }
 
-// NumberTransactionsInMonth retrieves the number of transactions for each month.
-func (f *FinanceService) NumberTransactionsInMonth() ([]models.TransactionsByMonth, error) {
+// mergeMonths converts the monthly totals of every currency into the reporting currency and
+// merges the currencies of each month, keeping the chronological order of the totals.
+func mergeMonths(monthly []models.MonthlyTotals, rates exchange.RateProvider, currency string) ([]models.TransactionsByMonth, error) {
 	transactions := []models.TransactionsByMonth{} // Slice to hold transactions by month
+	for _, m := range monthly {
+		from := m.Currency
+		if from == "" {
+			from = currency // Rows imported before currencies were tracked
+		}
 
-	// Map of month numbers to their names
-	months := map[int]string{
-		1:  "January",
-		2:  "February",
-		3:  "March",
-		4:  "April",
-		5:  "May",
-		6:  "June",
-		7:  "July",
-		8:  "August",
-		9:  "September",
-		10: "October",
-		11: "November",
-		12: "December",
-	}
-
-	// Iterate through each month to count transactions
-	for monthNumber, monthName := range months {
-		count, err := countTransactionsByMonth(monthNumber) // Call function to count transactions for the month
+		debit, err := exchange.Convert(rates, m.DebitTotal, from, currency)
+		if err != nil {
+			return nil, fmt.Errorf("error converting %s debits of %d-%02d: %w", from, m.Year, m.Month, err)
+		}
+		credit, err := exchange.Convert(rates, m.CreditTotal, from, currency)
 		if err != nil {
-			return nil, fmt.Errorf("error counting transactions for month %d: %w", monthNumber, err)
+			return nil, fmt.Errorf("error converting %s credits of %d-%02d: %w", from, m.Year, m.Month, err)
 		}
 
-		// If there are transactions for the month, append to the slice
-		if count != 0 {
-			newTransaction := models.TransactionsByMonth{
-				Total: count,
-				Month: monthName,
-			}
-			transactions = append(transactions, newTransaction) // Append the new transaction to the array
+		// Start a new month unless the previous row was another currency of the same month
+		last := len(transactions) - 1
+		if last < 0 || transactions[last].Year != m.Year || transactions[last].Month != m.Month {
+			transactions = append(transactions, models.TransactionsByMonth{Year: m.Year, Month: m.Month})
+			last++
+		}
+		transactions[last].Total += m.Count
+		transactions[last].DebitTotal += debit
+		transactions[last].CreditTotal += credit
+		transactions[last].Net = transactions[last].CreditTotal + transactions[last].DebitTotal
+	}
+	return transactions, nil
+}
+
+// mergeCategories converts the category totals of every currency into the reporting currency and
+// merges the currencies of each category, listing the categories with the largest spending first.
+func mergeCategories(totals []models.CategoryTotals, rates exchange.RateProvider, currency string) ([]models.TransactionsByCategory, error) {
+	categories := []models.TransactionsByCategory{} // Slice to hold transactions by category
+	index := map[string]int{}                       // Position of each category in categories
+	for _, t := range totals {
+		from := t.Currency
+		if from == "" {
+			from = currency // Rows imported before currencies were tracked
 		}
+
+		debit, err := exchange.Convert(rates, t.DebitTotal, from, currency)
+		if err != nil {
+			return nil, fmt.Errorf("error converting %s debits of category %q: %w", from, t.Category, err)
+		}
+		credit, err := exchange.Convert(rates, t.CreditTotal, from, currency)
+		if err != nil {
+			return nil, fmt.Errorf("error converting %s credits of category %q: %w", from, t.Category, err)
+		}
+
+		i, ok := index[t.Category]
+		if !ok {
+			i = len(categories)
+			index[t.Category] = i
+			categories = append(categories, models.TransactionsByCategory{Category: t.Category})
+		}
+		categories[i].Total += t.Count
+		categories[i].DebitTotal += debit
+		categories[i].CreditTotal += credit
+		categories[i].Net = categories[i].CreditTotal + categories[i].DebitTotal
 	}
 
-	return transactions, nil // Return the slice of transactions by month
+	// Debits are negative, so the largest spending sorts first; ties keep alphabetical order
+	slices.SortStableFunc(categories, func(a, b models.TransactionsByCategory) int {
+		if c := cmp.Compare(a.DebitTotal, b.DebitTotal); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Category, b.Category)
+	})
+	return categories, nil
 }
 
-// countTransactionsByMonth counts the number of transactions for a given month.
-func countTransactionsByMonth(monthNumber int) (int64, error) {
-	var count int64
-	// Query to count transactions for the specified month
-	if err := config.GetDB().Model(&models.SQLDocument{}).Where("MONTH(date) = ?", monthNumber).Count(&count).Error; err != nil {
-		return 0, fmt.Errorf("failed to count transactions for month %d: %w", monthNumber, err)
+// AddStatement adds the transactions of the summary's period listed in statements to a summary,
+// keeping at most limit of the most recent ones in chronological order.
+func AddStatement(data *models.EmailData, provider StatementProvider, limit int) error {
+	// Ask for one more row than needed to tell whether the ledger was truncated
+	entries, err := provider.Entries(data.Period, limit+1)
+	if err != nil {
+		return fmt.Errorf("error retrieving statement entries: %w", err)
+	}
+
+	data.EntriesTruncated = len(entries) > limit
+	if data.EntriesTruncated {
+		entries = entries[:limit]
+	}
+	slices.Reverse(entries) // Oldest first
+
+	for i := range entries {
+		if entries[i].Currency == "" {
+			entries[i].Currency = data.Currency // Rows imported before currencies were tracked
+		}
+	}
+	data.Entries = entries
+	return nil
+}
+
+// Entries retrieves the most recent transactions of the account's ledger in the period, newest first.
+func (f *FinanceService) Entries(period models.Period, limit int) ([]models.StatementEntry, error) {
+	var rows []struct {
+		Date          time.Time
+		IdTransaction uint
+		Amount        money.Amount
+		Currency      string
+		Description   string
+		Category      string
+	}
+	err := f.ledger(period).
+		Select("date, id_transaction, amount_cents AS amount, currency, description, category").
+		Order("date DESC, id_transaction DESC").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get statement entries: %w", err)
+	}
+
+	entries := make([]models.StatementEntry, len(rows))
+	for i, r := range rows {
+		entries[i] = models.StatementEntry{
+			Date:          r.Date.Format("2006-01-02"),
+			IdTransaction: r.IdTransaction,
+			Amount:        r.Amount,
+			Currency:      r.Currency,
+			Description:   r.Description,
+			Category:      r.Category,
+		}
+	}
+	return entries, nil
+}
+
+// CurrencyTotals aggregates the account's ledger in the period per currency in a single grouped
+// query. Integer cents keep the sums exact.
+func (f *FinanceService) CurrencyTotals(period models.Period) ([]models.CurrencyTotals, error) {
+	var totals []models.CurrencyTotals
+	err := f.ledger(period).
+		Select(`currency,
+			COALESCE(SUM(amount_cents), 0) AS balance,
+			COALESCE(SUM(CASE WHEN amount_cents < 0 THEN amount_cents ELSE 0 END), 0) AS debit_total,
+			COALESCE(SUM(CASE WHEN amount_cents < 0 THEN 1 ELSE 0 END), 0) AS debit_count,
+			COALESCE(SUM(CASE WHEN amount_cents > 0 THEN amount_cents ELSE 0 END), 0) AS credit_total,
+			COALESCE(SUM(CASE WHEN amount_cents > 0 THEN 1 ELSE 0 END), 0) AS credit_count`).
+		Group("currency").
+		Order("currency").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get currency totals: %w", err)
+	}
+	return totals, nil // Return the totals of each currency
+}
+
+// AmountStats aggregates the distribution of the amounts of the account's ledger in the period
+// per currency in a single grouped query, so the ledger is never loaded into memory.
+func (f *FinanceService) AmountStats(period models.Period) ([]models.AmountStats, error) {
+	var stats []models.AmountStats
+	err := f.ledger(period).
+		Select(`currency,
+			COUNT(*) AS count,
+			COALESCE(SUM(CASE WHEN amount_cents < 0 THEN 1 ELSE 0 END), 0) AS debit_count,
+			COALESCE(SUM(CASE WHEN amount_cents > 0 THEN 1 ELSE 0 END), 0) AS credit_count,
+			MIN(amount_cents) AS min_amount,
+			MAX(amount_cents) AS max_amount,
+			COALESCE(MIN(CASE WHEN amount_cents < 0 THEN amount_cents END), 0) AS largest_debit,
+			COALESCE(MAX(CASE WHEN amount_cents > 0 THEN amount_cents END), 0) AS largest_credit,
+			AVG(amount_cents) AS mean,
+			VAR_POP(amount_cents) AS variance`).
+		Group("currency").
+		Order("currency").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get amount statistics: %w", err)
+	}
+	return stats, nil // Return the distribution of each currency
+}
+
+// AmountsAt retrieves limit transactions of the account's ledger in the period in ascending order
+// of amount, skipping the first offset ones. The amounts in the currencies of scales are multiplied
+// by their scale first, so that every currency is ordered as if converted into a single one.
+func (f *FinanceService) AmountsAt(period models.Period, scales map[string]float64, offset, limit int) ([]models.CurrencyAmount, error) {
+	order := clause.Expr{SQL: "amount_cents"}
+	if len(scales) > 0 {
+		currencies := make([]string, 0, len(scales))
+		for c := range scales {
+			currencies = append(currencies, c)
+		}
+		slices.Sort(currencies) // Same SQL for the same scales
+
+		sql := "amount_cents * CASE currency"
+		var vars []interface{}
+		for _, c := range currencies {
+			sql += " WHEN ? THEN ?"
+			vars = append(vars, c, scales[c])
+		}
+		order = clause.Expr{SQL: sql + " ELSE 1 END", Vars: vars}
+	}
+
+	var amounts []models.CurrencyAmount
+	err := f.ledger(period).
+		Select("currency, amount_cents AS amount").
+		Clauses(clause.OrderBy{Expression: order}).
+		Offset(offset).
+		Limit(limit).
+		Scan(&amounts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction amounts: %w", err)
+	}
+	return amounts, nil // Return the amounts at the position
+}
+
+// CategoryTotals aggregates the account's ledger in the period per category and currency in a
+// single grouped query.
+func (f *FinanceService) CategoryTotals(period models.Period) ([]models.CategoryTotals, error) {
+	var totals []models.CategoryTotals
+	err := f.ledger(period).
+		Select(`category, currency,
+			COUNT(*) AS count,
+			COALESCE(SUM(CASE WHEN amount_cents < 0 THEN amount_cents ELSE 0 END), 0) AS debit_total,
+			COALESCE(SUM(CASE WHEN amount_cents > 0 THEN amount_cents ELSE 0 END), 0) AS credit_total`).
+		Group("category, currency").
+		Order("category, currency").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category totals: %w", err)
+	}
+	return totals, nil // Return the totals of each category and currency
+}
+
+// MonthlyTotals aggregates the account's ledger in the period per year, month and currency in a
+// single grouped query, in chronological order.
+func (f *FinanceService) MonthlyTotals(period models.Period) ([]models.MonthlyTotals, error) {
+	var totals []models.MonthlyTotals
+	err := f.ledger(period).
+		Select(`YEAR(date) AS year, MONTH(date) AS month, currency,
+			COUNT(*) AS count,
+			COALESCE(SUM(CASE WHEN amount_cents < 0 THEN amount_cents ELSE 0 END), 0) AS debit_total,
+			COALESCE(SUM(CASE WHEN amount_cents > 0 THEN amount_cents ELSE 0 END), 0) AS credit_total`).
+		Group("YEAR(date), MONTH(date), currency").
+		Order("year, month, currency").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monthly totals: %w", err)
 	}
-	return count, nil // Return the count of transactions
+	return totals, nil // Return the totals of each month and currency
 }
@@ -1,9 +1,14 @@
 package summary
 
 import (
+	"encoding/json"
+	"math/big"
 	"testing"
+	"time"
 
+	"stori_challenge/pkg/exchange"
 	"stori_challenge/pkg/models"
+	"stori_challenge/pkg/money"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -14,56 +19,102 @@ type MockSummaryProvider struct {
 	mock.Mock // Embedding the mock package to enable mocking behavior
 }
 
-// TotalBalance returns the total balance for the mock provider.
-func (m *MockSummaryProvider) TotalBalance() (float64, error) {
-	args := m.Called()                          // Call the mock's Called method
-	return args.Get(0).(float64), args.Error(1) // Return the first argument and the error
+// CurrencyTotals returns the totals of each currency for the mock provider.
+func (m *MockSummaryProvider) CurrencyTotals(period models.Period) ([]models.CurrencyTotals, error) {
+	args := m.Called(period)                                    // Call the mock's Called method
+	return args.Get(0).([]models.CurrencyTotals), args.Error(1) // Return the first argument and the error
 }
 
-// AverageDebitAmount returns the average debit amount for the mock provider.
-func (m *MockSummaryProvider) AverageDebitAmount() (float64, error) {
-	args := m.Called()                          // Call the mock's Called method
-	return args.Get(0).(float64), args.Error(1) // Return the first argument and the error
+// MonthlyTotals returns the totals of each month and currency for the mock provider.
+func (m *MockSummaryProvider) MonthlyTotals(period models.Period) ([]models.MonthlyTotals, error) {
+	args := m.Called(period)                                   // Call the mock's Called method
+	return args.Get(0).([]models.MonthlyTotals), args.Error(1) // Return the first argument and the error
 }
 
-// AverageCreditAmount returns the average credit amount for the mock provider.
-func (m *MockSummaryProvider) AverageCreditAmount() (float64, error) {
-	args := m.Called()                          // Call the mock's Called method
-	return args.Get(0).(float64), args.Error(1) // Return the first argument and the error
+// AmountStats returns the distribution of the amounts of each currency for the mock provider.
+func (m *MockSummaryProvider) AmountStats(period models.Period) ([]models.AmountStats, error) {
+	args := m.Called(period)                                 // Call the mock's Called method
+	return args.Get(0).([]models.AmountStats), args.Error(1) // Return the first argument and the error
 }
 
-// NumberTransactionsInMonth returns a slice of transactions aggregated by month for the mock provider.
-func (m *MockSummaryProvider) NumberTransactionsInMonth() ([]models.TransactionsByMonth, error) {
-	args := m.Called()                                               // Call the mock's Called method
-	return args.Get(0).([]models.TransactionsByMonth), args.Error(1) // Return the first argument and the error
+// AmountsAt returns the transactions at a position of the ordered amounts for the mock provider.
+func (m *MockSummaryProvider) AmountsAt(period models.Period, scales map[string]float64, offset, limit int) ([]models.CurrencyAmount, error) {
+	args := m.Called(period, scales, offset, limit)             // Call the mock's Called method
+	return args.Get(0).([]models.CurrencyAmount), args.Error(1) // Return the first argument and the error
+}
+
+// CategoryTotals returns the totals of each category and currency for the mock provider.
+func (m *MockSummaryProvider) CategoryTotals(period models.Period) ([]models.CategoryTotals, error) {
+	args := m.Called(period)                                    // Call the mock's Called method
+	return args.Get(0).([]models.CategoryTotals), args.Error(1) // Return the first argument and the error
+}
+
+// Entries returns the most recent transactions for the mock provider.
+func (m *MockSummaryProvider) Entries(period models.Period, limit int) ([]models.StatementEntry, error) {
+	args := m.Called(period, limit)                             // Call the mock's Called method
+	return args.Get(0).([]models.StatementEntry), args.Error(1) // Return the first argument and the error
 }
 
 // TestCreateSummary tests the CreateSummary function using a mocked SummaryProvider.
 func TestCreateSummary(t *testing.T) {
-	mockProvider := new(MockSummaryProvider) // Create a new instance of the mock provider
+	mockProvider := new(MockSummaryProvider)                      // Create a new instance of the mock provider
+	period := models.Period{From: "2024-01-01", To: "2024-02-29"} // Period of the summary
 
 	// Define the expected behavior for the mock methods
-	mockProvider.On("TotalBalance").Return(1500.50, nil)
-	mockProvider.On("AverageDebitAmount").Return(500.25, nil)
-	mockProvider.On("AverageCreditAmount").Return(1000.75, nil)
-	mockProvider.On("NumberTransactionsInMonth").Return([]models.TransactionsByMonth{
-		{Month: "January", Total: 5},  // January transactions
-		{Month: "February", Total: 3}, // February transactions
+	mockProvider.On("CurrencyTotals", period).Return([]models.CurrencyTotals{
+		{Currency: "USD", Balance: 150050, DebitTotal: -100050, DebitCount: 2, CreditTotal: 250100, CreditCount: 2},
+	}, nil)
+	mockProvider.On("MonthlyTotals", period).Return([]models.MonthlyTotals{
+		{Year: 2024, Month: time.January, Currency: "USD", Count: 5, DebitTotal: -100050, CreditTotal: 200000}, // January transactions
+		{Year: 2024, Month: time.February, Currency: "USD", Count: 3, DebitTotal: 0, CreditTotal: 50100},       // February transactions
+	}, nil)
+	mockProvider.On("AmountStats", period).Return([]models.AmountStats{
+		// Amounts 1000.00, -500.00, -500.50 and 1501.00
+		{Currency: "USD", Count: 4, DebitCount: 2, CreditCount: 2, MinAmount: -50050, MaxAmount: 150100,
+			LargestDebit: -50050, LargestCredit: 150100, Mean: 37500, Variance: 7976565625},
+	}, nil)
+	mockProvider.On("AmountsAt", period, map[string]float64{}, 1, 2).Return([]models.CurrencyAmount{
+		{Currency: "USD", Amount: -50000}, {Currency: "USD", Amount: 100000}, // Middle amounts
+	}, nil)
+	mockProvider.On("CurrencyTotals", models.Period{To: "2024-02-29"}).Return([]models.CurrencyTotals{
+		{Currency: "USD", Balance: 200050}, // Including the transactions before the period
+	}, nil)
+	mockProvider.On("CategoryTotals", period).Return([]models.CategoryTotals{
+		{Category: "", Currency: "USD", Count: 2, CreditTotal: 250100},
+		{Category: "Groceries", Currency: "USD", Count: 2, DebitTotal: -100050},
 	}, nil)
 
 	// Prepare the expected EmailData result
 	expectedEmailData := models.EmailData{
-		TotalBalance:        1500.50,
-		AverageDebitAmount:  500.25,
-		AverageCreditAmount: 1000.75,
+		Currency:            "USD",
+		Period:              period,
+		Balances:            []models.CurrencyBalance{{Currency: "USD", Balance: 150050, Converted: 150050}},
+		TotalBalance:        150050,
+		AverageDebitAmount:  -50025,
+		AverageCreditAmount: 125050,
+		Statistics: models.Statistics{
+			DebitCount:     2,
+			CreditCount:    2,
+			MedianAmount:   25000,
+			MinAmount:      -50050,
+			MaxAmount:      150100,
+			LargestDebit:   -50050,
+			LargestCredit:  150100,
+			StdDevAmount:   89312,
+			ClosingBalance: 200050,
+		},
 		Transactions: []models.TransactionsByMonth{
-			{Month: "January", Total: 5},
-			{Month: "February", Total: 3},
+			{Year: 2024, Month: time.January, Total: 5, DebitTotal: -100050, CreditTotal: 200000, Net: 99950},
+			{Year: 2024, Month: time.February, Total: 3, CreditTotal: 50100, Net: 50100},
+		},
+		Categories: []models.TransactionsByCategory{
+			{Category: "Groceries", Total: 2, DebitTotal: -100050, Net: -100050}, // Largest spending first
+			{Category: "", Total: 2, CreditTotal: 250100, Net: 250100},
 		},
 	}
 
 	// Call the CreateSummary function with the mock provider
-	result, err := CreateSummary(mockProvider)
+	result, err := CreateSummary(mockProvider, exchange.Table{}, "USD", period)
 
 	// Assert that there was no error and the result matches the expected data
 	assert.NoError(t, err)                     // Check that the error is nil
@@ -72,3 +123,206 @@ func TestCreateSummary(t *testing.T) {
 	// Verify that the mock expectations were met
 	mockProvider.AssertExpectations(t) // Ensure all mocked methods were called as expected
 }
+
+// TestCreateSummaryConvertsCurrencies tests that balances in other currencies are converted
+// into the reporting currency before being aggregated.
+func TestCreateSummaryConvertsCurrencies(t *testing.T) {
+	mockProvider := new(MockSummaryProvider)
+	mockProvider.On("CurrencyTotals", models.Period{}).Return([]models.CurrencyTotals{
+		{Currency: "MXN", Balance: 20000, DebitTotal: -10000, DebitCount: 1, CreditTotal: 30000, CreditCount: 1},
+		{Currency: "USD", Balance: 1000, CreditTotal: 1000, CreditCount: 1},
+	}, nil)
+	mockProvider.On("MonthlyTotals", models.Period{}).Return([]models.MonthlyTotals{
+		{Year: 2023, Month: time.December, Currency: "MXN", Count: 1, CreditTotal: 30000},
+		{Year: 2024, Month: time.January, Currency: "MXN", Count: 1, DebitTotal: -10000},
+		{Year: 2024, Month: time.January, Currency: "USD", Count: 1, CreditTotal: 1000},
+	}, nil)
+
+	mockProvider.On("AmountStats", models.Period{}).Return([]models.AmountStats{
+		// Amounts -100.00 and 300.00 MXN
+		{Currency: "MXN", Count: 2, DebitCount: 1, CreditCount: 1, MinAmount: -10000, MaxAmount: 30000,
+			LargestDebit: -10000, LargestCredit: 30000, Mean: 10000, Variance: 400000000},
+		{Currency: "USD", Count: 1, CreditCount: 1, MinAmount: 1000, MaxAmount: 1000, LargestCredit: 1000, Mean: 1000},
+	}, nil)
+	// MXN amounts are ordered as if converted into USD
+	mockProvider.On("AmountsAt", models.Period{}, map[string]float64{"MXN": 0.05}, 1, 1).Return([]models.CurrencyAmount{
+		{Currency: "USD", Amount: 1000},
+	}, nil)
+
+	mockProvider.On("CategoryTotals", models.Period{}).Return([]models.CategoryTotals{
+		{Category: "", Currency: "MXN", Count: 1, CreditTotal: 30000},
+		{Category: "", Currency: "USD", Count: 1, CreditTotal: 1000},
+		{Category: "Rent", Currency: "MXN", Count: 1, DebitTotal: -10000},
+	}, nil)
+
+	rates := exchange.Table{{From: "USD", To: "MXN"}: big.NewRat(20, 1)}
+	result, err := CreateSummary(mockProvider, rates, "USD", models.Period{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.CurrencyBalance{
+		{Currency: "MXN", Balance: 20000, Converted: 1000},
+		{Currency: "USD", Balance: 1000, Converted: 1000},
+	}, result.Balances)
+	assert.Equal(t, money.Amount(2000), result.TotalBalance)       // 10.00 USD + 10.00 USD
+	assert.Equal(t, money.Amount(-500), result.AverageDebitAmount) // -100.00 MXN
+	assert.Equal(t, money.Amount(1250), result.AverageCreditAmount)
+
+	// Statistics are computed over the converted amounts; without a start the closing balance is the total
+	assert.Equal(t, models.Statistics{
+		DebitCount: 1, CreditCount: 2,
+		MedianAmount: 1000, MinAmount: -500, MaxAmount: 1500,
+		LargestDebit: -500, LargestCredit: 1500,
+		StdDevAmount: 850, ClosingBalance: 2000,
+	}, result.Statistics)
+
+	// Months are merged across currencies, in chronological order
+	assert.Equal(t, []models.TransactionsByMonth{
+		{Year: 2023, Month: time.December, Total: 1, CreditTotal: 1500, Net: 1500},
+		{Year: 2024, Month: time.January, Total: 2, DebitTotal: -500, CreditTotal: 1000, Net: 500},
+	}, result.Transactions)
+
+	// Categories are merged across currencies
+	assert.Equal(t, []models.TransactionsByCategory{
+		{Category: "Rent", Total: 1, DebitTotal: -500, Net: -500},
+		{Category: "", Total: 2, CreditTotal: 2500, Net: 2500},
+	}, result.Categories)
+
+	// A currency without a known rate can't be reported
+	_, err = CreateSummary(mockProvider, exchange.Table{}, "USD", models.Period{})
+	assert.ErrorIs(t, err, exchange.ErrRateNotFound)
+}
+
+// TestAddStatement tests that statement entries are listed oldest first and truncated to the limit.
+func TestAddStatement(t *testing.T) {
+	mockProvider := new(MockSummaryProvider)
+
+	// Newest first, one more row than the limit of 2
+	period := models.Period{Preset: "month", From: "2024-08-01", To: "2024-08-31"}
+	mockProvider.On("Entries", period, 3).Return([]models.StatementEntry{
+		{Date: "2024-08-30", IdTransaction: 4, Amount: 1000, Currency: "USD"},
+		{Date: "2024-08-02", IdTransaction: 3, Amount: -500, Currency: ""},
+		{Date: "2024-07-15", IdTransaction: 1, Amount: 6000, Currency: "USD"},
+	}, nil)
+
+	data := models.EmailData{Currency: "USD", Period: period}
+	err := AddStatement(&data, mockProvider, 2)
+
+	assert.NoError(t, err)
+	assert.True(t, data.EntriesTruncated)
+	assert.Equal(t, []models.StatementEntry{
+		{Date: "2024-08-02", IdTransaction: 3, Amount: -500, Currency: "USD"}, // Account currency when missing
+		{Date: "2024-08-30", IdTransaction: 4, Amount: 1000, Currency: "USD"},
+	}, data.Entries)
+	mockProvider.AssertExpectations(t)
+}
+
+// TestSummaryJSON tests the JSON representation of a summary returned by the API.
+func TestSummaryJSON(t *testing.T) {
+	mockProvider := new(MockSummaryProvider)
+	period := models.Period{Preset: "ytd", From: "2024-01-01", To: "2024-08-15"}
+	mockProvider.On("CurrencyTotals", period).Return([]models.CurrencyTotals{
+		{Currency: "USD", Balance: 3974, DebitTotal: -3076, DebitCount: 2, CreditTotal: 7050, CreditCount: 2},
+	}, nil)
+	mockProvider.On("MonthlyTotals", period).Return([]models.MonthlyTotals{
+		{Year: 2024, Month: time.July, Currency: "USD", Count: 2, DebitTotal: -3076, CreditTotal: 7050},
+	}, nil)
+
+	mockProvider.On("AmountStats", period).Return([]models.AmountStats{
+		// Amounts 60.50, -10.30, -20.46 and 10.00
+		{Currency: "USD", Count: 4, DebitCount: 2, CreditCount: 2, MinAmount: -2046, MaxAmount: 6050,
+			LargestDebit: -2046, LargestCredit: 6050, Mean: 993.5, Variance: 9725336.75},
+	}, nil)
+	mockProvider.On("AmountsAt", period, map[string]float64{}, 1, 2).Return([]models.CurrencyAmount{
+		{Currency: "USD", Amount: -1030}, {Currency: "USD", Amount: 1000},
+	}, nil)
+	mockProvider.On("CurrencyTotals", models.Period{To: "2024-08-15"}).Return([]models.CurrencyTotals{
+		{Currency: "USD", Balance: 3974},
+	}, nil)
+	mockProvider.On("CategoryTotals", period).Return([]models.CategoryTotals{
+		{Category: "", Currency: "USD", Count: 4, DebitTotal: -3076, CreditTotal: 7050},
+	}, nil)
+
+	result, err := CreateSummary(mockProvider, exchange.Table{}, "USD", period)
+	assert.NoError(t, err)
+
+	encoded, err := json.Marshal(result)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"currency": "USD",
+		"period": {"preset": "ytd", "from": "2024-01-01", "to": "2024-08-15"},
+		"balances": [{"currency": "USD", "balance": 39.74, "converted": 39.74}],
+		"totalBalance": 39.74,
+		"averageDebitAmount": -15.38,
+		"averageCreditAmount": 35.25,
+		"statistics": {
+			"debitCount": 2, "creditCount": 2,
+			"medianAmount": -0.15, "minAmount": -20.46, "maxAmount": 60.5,
+			"largestDebit": -20.46, "largestCredit": 60.5,
+			"stdDevAmount": 31.19, "closingBalance": 39.74
+		},
+		"transactions": [{"year": 2024, "month": 7, "total": 2, "debitTotal": -30.76, "creditTotal": 70.5, "net": 39.74}],
+		"categories": [{"category": "", "total": 4, "debitTotal": -30.76, "creditTotal": 70.5, "net": 39.74}]
+	}`, string(encoded))
+}
+
+// parsePeriodTests lists the periods built from presets and from/to dates on 2024-08-15.
+var parsePeriodTests = []struct {
+	preset, from, to string        // Parameters of the period
+	expected         models.Period // Expected period, when valid
+	valid            bool          // Whether the parameters are valid
+}{
+	{"", "", "", models.Period{}, true},
+	{"all", "", "", models.Period{}, true},
+	{"month", "", "", models.Period{Preset: "month", From: "2024-08-01", To: "2024-08-15"}, true},
+	{"Quarter", "", "", models.Period{Preset: "quarter", From: "2024-07-01", To: "2024-08-15"}, true},
+	{"ytd", "", "", models.Period{Preset: "ytd", From: "2024-01-01", To: "2024-08-15"}, true},
+	{"year", "", "", models.Period{Preset: "year", From: "2023-08-16", To: "2024-08-15"}, true},
+	{"", "2024-02-01", "", models.Period{From: "2024-02-01"}, true},
+	{"", "", "2024-02-29", models.Period{To: "2024-02-29"}, true},
+	{"", "2024-02-01", "2024-02-29", models.Period{From: "2024-02-01", To: "2024-02-29"}, true},
+	{"", "2024-02-29", "2024-02-01", models.Period{}, false}, // Ends before it starts
+	{"", "2023-02-29", "", models.Period{}, false},           // Not a date
+	{"", "02/01/2024", "", models.Period{}, false},           // Not YYYY-MM-DD
+	{"week", "", "", models.Period{}, false},                 // Unknown preset
+	{"month", "2024-02-01", "", models.Period{}, false},      // Preset and dates
+}
+
+// TestParsePeriod tests the periods built from presets and from/to dates.
+func TestParsePeriod(t *testing.T) {
+	now := time.Date(2024, time.August, 15, 18, 30, 0, 0, time.UTC)
+	for _, test := range parsePeriodTests {
+		period, err := ParsePeriod(test.preset, test.from, test.to, now)
+		if !test.valid {
+			assert.ErrorIs(t, err, ErrInvalidPeriod, "%q %q %q", test.preset, test.from, test.to)
+			continue
+		}
+		assert.NoError(t, err, "%q %q %q", test.preset, test.from, test.to)
+		assert.Equal(t, test.expected, period, "%q %q %q", test.preset, test.from, test.to)
+	}
+}
+
+// describeTests lists the statistics merged from the distributions of several currencies; the
+// median and closing balance are left to CreateSummary.
+var describeTests = []struct {
+	amounts  []models.AmountStats // Distributions of the amounts, already converted
+	expected models.Statistics    // Expected statistics
+}{
+	{nil, models.Statistics{}},
+	{[]models.AmountStats{
+		{Count: 1, DebitCount: 1, MinAmount: -500, MaxAmount: -500, LargestDebit: -500, Mean: -500},
+	}, models.Statistics{DebitCount: 1, MinAmount: -500, MaxAmount: -500, LargestDebit: -500}},
+	{[]models.AmountStats{
+		{Count: 2, CreditCount: 2, MinAmount: 100, MaxAmount: 300, LargestCredit: 300, Mean: 200, Variance: 10000}, // 100 and 300
+		{Count: 1, CreditCount: 1, MinAmount: 200, MaxAmount: 200, LargestCredit: 200, Mean: 200},                  // 200
+	}, models.Statistics{CreditCount: 3, MinAmount: 100, MaxAmount: 300, LargestCredit: 300, StdDevAmount: 82}},
+	{[]models.AmountStats{
+		{Count: 2, CreditCount: 1, MinAmount: 0, MaxAmount: 1, LargestCredit: 1, Mean: 0.5, Variance: 0.25},                               // 0 and 1
+		{Count: 2, DebitCount: 1, CreditCount: 1, MinAmount: -4, MaxAmount: 6, LargestDebit: -4, LargestCredit: 6, Mean: 1, Variance: 25}, // -4 and 6
+	}, models.Statistics{DebitCount: 1, CreditCount: 2, MinAmount: -4, MaxAmount: 6, LargestDebit: -4, LargestCredit: 6, StdDevAmount: 4}},
+}
+
+func TestDescribe(t *testing.T) {
+	for _, test := range describeTests {
+		assert.Equal(t, test.expected, describe(test.amounts), "%v", test.amounts)
+	}
+}
@@ -0,0 +1,10 @@
+// Package web holds the assets served or sent by the API, embedded into the binary so it runs
+// from any working directory.
+package web
+
+import "embed"
+
+// Templates holds the default email templates under template/, such as template/email_template.html.
+//
+//go:embed template/*.html template/*.txt
+var Templates embed.FS